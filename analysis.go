@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// sourceAnalyzer 在同一次解码中接收源文件的全部样本，多项分析共享一次读取
+type sourceAnalyzer interface {
+	add(samples []float64, channels, sampleRate int)
+}
+
+// refreshSourceAnalyses 在源文件内容变化后重新计算哈希，并重新运行已过期的分析。
+// 只有当文件大小或修改时间变化时才重新计算哈希，避免每次启动都读取全部源文件。
+// 分段录音的各段按顺序连续送入分析器，如同一个文件。
+func refreshSourceAnalyses(metadata *AudioMetadata, settings Settings) {
+	paths := metadata.SourcePaths()
+	if _, err := refreshSourceHash(metadata); err != nil {
+		log.Printf("Warning: Failed to hash %s: %v", metadata.SourceFilename, err)
+		return
+	}
+
+	var analyzers []sourceAnalyzer
+	var finishers []func() error
+
+	if metadata.QC == nil || metadata.QC.SourceHash != metadata.SourceHash {
+		qc := newQCAnalyzer()
+		analyzers = append(analyzers, qc)
+		finishers = append(finishers, func() error {
+			report, err := qc.finish()
+			if err != nil {
+				return fmt.Errorf("quality check: %w", err)
+			}
+			report.SourceHash = metadata.SourceHash
+			metadata.QC = report
+			return nil
+		})
+	}
+
+	configKey := settings.Indices.configKey()
+	if metadata.Indices == nil || metadata.Indices.SourceHash != metadata.SourceHash || metadata.Indices.ConfigKey != configKey {
+		indices := newIndicesAnalyzer(settings.Indices)
+		analyzers = append(analyzers, indices)
+		finishers = append(finishers, func() error {
+			result, err := indices.finish()
+			if err != nil {
+				return fmt.Errorf("acoustic indices: %w", err)
+			}
+			result.SourceHash = metadata.SourceHash
+			metadata.Indices = result
+			return nil
+		})
+	}
+
+	if metadata.Levels == nil || metadata.Levels.SourceHash != metadata.SourceHash {
+		levels := newLevelAnalyzer()
+		analyzers = append(analyzers, levels)
+		finishers = append(finishers, func() error {
+			result, err := levels.finish()
+			if err != nil {
+				return fmt.Errorf("sound levels: %w", err)
+			}
+			result.SourceHash = metadata.SourceHash
+			metadata.Levels = result
+			return nil
+		})
+	}
+
+	if metadata.Fingerprint == nil || metadata.Fingerprint.SourceHash != metadata.SourceHash || metadata.Fingerprint.Version != fingerprintVersion {
+		fingerprint := newFingerprintAnalyzer()
+		analyzers = append(analyzers, fingerprint)
+		finishers = append(finishers, func() error {
+			result, err := fingerprint.finish()
+			if err != nil {
+				return fmt.Errorf("fingerprint: %w", err)
+			}
+			result.SourceHash = metadata.SourceHash
+			metadata.Fingerprint = result
+			return nil
+		})
+	}
+
+	eventConfig := settings.Events.forFolder(filepath.ToSlash(filepath.Dir(metadata.SourceFilename)))
+	if eventConfig.disabled() {
+		metadata.Events = nil
+	} else if metadata.Events == nil || metadata.Events.SourceHash != metadata.SourceHash || metadata.Events.ConfigKey != eventConfig.configKey() {
+		events := newEventAnalyzer(eventConfig)
+		analyzers = append(analyzers, events)
+		finishers = append(finishers, func() error {
+			result, err := events.finish()
+			if err != nil {
+				return fmt.Errorf("event detection: %w", err)
+			}
+			result.SourceHash = metadata.SourceHash
+			result.keepReviews(metadata.Events)
+			metadata.Events = result
+			return nil
+		})
+	}
+
+	if !settings.Spectrogram.Disabled && metadata.DurationSeconds >= settings.Spectrogram.minDurationSeconds() {
+		relPath := spectrogramCachePath(metadata.SourceFilename)
+		manifest, err := loadCacheManifest(spectrogramDir)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		} else if _, statErr := os.Stat(filepath.Join(spectrogramDir, relPath)); statErr != nil || !manifest.isFresh(relPath, metadata.SourceHash, spectrogramFingerprint) {
+			spectrogram := newSpectrogramAnalyzer()
+			analyzers = append(analyzers, spectrogram)
+			finishers = append(finishers, func() error {
+				img, err := spectrogram.finish()
+				if err != nil {
+					return fmt.Errorf("spectrogram: %w", err)
+				}
+				if err := writeSpectrogramCache(relPath, metadata.SourceHash, img); err != nil {
+					return fmt.Errorf("spectrogram: %w", err)
+				}
+				return nil
+			})
+		}
+	}
+
+	// Calibration only shifts the stored dBFS values, so it is reapplied on every run
+	defer func() {
+		if metadata.Levels != nil {
+			metadata.Levels.applyCalibration(settings, metadata.Recorder)
+		}
+	}()
+
+	if len(analyzers) == 0 {
+		return
+	}
+	log.Printf("Analyzing %s (%d analyses)...", metadata.SourceFilename, len(analyzers))
+	var err error
+	for _, path := range paths {
+		err = forEachAudioBlock(path, 1, func(samples []float64, channels, sampleRate int) {
+			for _, a := range analyzers {
+				a.add(samples, channels, sampleRate)
+			}
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to analyze %s: %v", metadata.SourceFilename, err)
+		return
+	}
+	for _, finish := range finishers {
+		if err := finish(); err != nil {
+			log.Printf("Warning: %s: %v", metadata.SourceFilename, err)
+		}
+	}
+}
+
+// rebaseSourceHash 在只改写了元数据块、音频内容不变时，把各项分析结果和 m4a、频谱图缓存记录
+// 转到新的文件哈希上，避免重新分析和重新转码
+func rebaseSourceHash(metadata *AudioMetadata) error {
+	paths := metadata.SourcePaths()
+	stat, err := sourceStat(paths)
+	if err != nil {
+		return err
+	}
+	newHash, err := hashSourceFiles(paths)
+	if err != nil {
+		return err
+	}
+	oldHash := metadata.SourceHash
+	metadata.SourceHash = newHash
+	metadata.SourceStat = stat
+	if oldHash == "" || oldHash == newHash {
+		return nil
+	}
+	if metadata.QC != nil && metadata.QC.SourceHash == oldHash {
+		metadata.QC.SourceHash = newHash
+	}
+	if metadata.Indices != nil && metadata.Indices.SourceHash == oldHash {
+		metadata.Indices.SourceHash = newHash
+	}
+	if metadata.Levels != nil && metadata.Levels.SourceHash == oldHash {
+		metadata.Levels.SourceHash = newHash
+	}
+	if metadata.Fingerprint != nil && metadata.Fingerprint.SourceHash == oldHash {
+		metadata.Fingerprint.SourceHash = newHash
+	}
+	if metadata.Events != nil && metadata.Events.SourceHash == oldHash {
+		metadata.Events.SourceHash = newHash
+	}
+	for _, result := range metadata.External {
+		if result != nil && result.SourceHash == oldHash {
+			result.SourceHash = newHash
+		}
+	}
+	caches := map[string]string{
+		m4aDir:         sidecarBase(metadata.SourceFilename) + ".m4a",
+		spectrogramDir: spectrogramCachePath(metadata.SourceFilename),
+	}
+	for dir, relPath := range caches {
+		err := updateCacheManifest(dir, func(m *CacheManifest) {
+			if entry, ok := m.Entries[filepath.ToSlash(relPath)]; ok && entry.SourceHash == oldHash {
+				m.record(relPath, newHash, entry.EncoderFingerprint)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
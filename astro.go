@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 太阳中心位于这些高度角（度）时分别为日出日落、民用晨昏蒙影和航海晨昏蒙影的开始或结束
+const (
+	sunriseAltitude          = -0.833 // 含大气折射和太阳视半径
+	civilTwilightAltitude    = -6.0
+	nauticalTwilightAltitude = -12.0
+	daylightAltitude         = 6.0 // 高于该角度视为白天，低于则为晨昏
+)
+
+// 时段分类，按录音开始时的太阳高度角划分：低于航海晨昏蒙影为夜间，
+// 航海晨昏蒙影至太阳高度 6° 之间按太阳升降分为黎明和黄昏，其余为白天
+const (
+	timeOfDayDawn  = "dawn"
+	timeOfDayDay   = "day"
+	timeOfDayDusk  = "dusk"
+	timeOfDayNight = "night"
+)
+
+// timeOfDayClasses 是网站筛选中各时段的顺序与名称
+var timeOfDayClasses = []struct{ Key, Label string }{
+	{timeOfDayDawn, "黎明"},
+	{timeOfDayDay, "白天"},
+	{timeOfDayDusk, "黄昏"},
+	{timeOfDayNight, "夜间"},
+}
+
+// SkyContext 是录音开始时的太阳和月亮状况，由录音时间和坐标计算。
+// 各时刻为录音当地日期内的时刻，极昼极夜等当天不发生时为空
+type SkyContext struct {
+	SunAltitude      float64    `json:"sun_altitude"` // 度
+	Sunrise          *time.Time `json:"sunrise,omitempty"`
+	Sunset           *time.Time `json:"sunset,omitempty"`
+	CivilDawn        *time.Time `json:"civil_dawn,omitempty"`
+	CivilDusk        *time.Time `json:"civil_dusk,omitempty"`
+	NauticalDawn     *time.Time `json:"nautical_dawn,omitempty"`
+	NauticalDusk     *time.Time `json:"nautical_dusk,omitempty"`
+	MoonPhase        float64    `json:"moon_phase"`        // 0 为新月，0.5 为满月
+	MoonIllumination float64    `json:"moon_illumination"` // 月面被照亮的比例 (0-1)
+	TimeOfDay        string     `json:"time_of_day"`       // dawn、day、dusk 或 night
+	SunWithheld      bool       `json:"-"`                 // 位置保密时不发布可以反推位置的太阳高度和日出日落时刻
+}
+
+// refreshSkyContext 根据录音时间和坐标重新计算 Sky，没有坐标或时间时清空
+func refreshSkyContext(meta *AudioMetadata) {
+	if meta.Latitude == nil || meta.Longitude == nil || meta.RecordDate.IsZero() {
+		meta.Sky = nil
+		return
+	}
+	meta.Sky = computeSkyContext(meta.RecordDate, *meta.Latitude, *meta.Longitude)
+}
+
+func computeSkyContext(t time.Time, lat, lon float64) *SkyContext {
+	sky := &SkyContext{SunAltitude: math.Round(sunAltitude(t, lat, lon)*10) / 10}
+	sky.Sunrise, sky.Sunset = sunTimes(t, lat, lon, sunriseAltitude)
+	sky.CivilDawn, sky.CivilDusk = sunTimes(t, lat, lon, civilTwilightAltitude)
+	sky.NauticalDawn, sky.NauticalDusk = sunTimes(t, lat, lon, nauticalTwilightAltitude)
+	phase := moonPhase(t)
+	sky.MoonPhase = math.Round(phase*1000) / 1000
+	sky.MoonIllumination = math.Round((1-math.Cos(2*math.Pi*phase))/2*1000) / 1000
+
+	altitude := sunAltitude(t, lat, lon)
+	switch {
+	case altitude < nauticalTwilightAltitude:
+		sky.TimeOfDay = timeOfDayNight
+	case altitude >= daylightAltitude:
+		sky.TimeOfDay = timeOfDayDay
+	case sunAltitude(t.Add(time.Minute), lat, lon) > altitude:
+		sky.TimeOfDay = timeOfDayDawn
+	default:
+		sky.TimeOfDay = timeOfDayDusk
+	}
+	return sky
+}
+
+// TimeOfDayLabel 返回时段的中文名称
+func (s *SkyContext) TimeOfDayLabel() string {
+	for _, c := range timeOfDayClasses {
+		if c.Key == s.TimeOfDay {
+			return c.Label
+		}
+	}
+	return s.TimeOfDay
+}
+
+// MoonPhaseName 返回月相名称
+func (s *SkyContext) MoonPhaseName() string {
+	names := []string{"新月", "蛾眉月", "上弦月", "盈凸月", "满月", "亏凸月", "下弦月", "残月"}
+	return names[int(math.Floor(s.MoonPhase*8+0.5))%8]
+}
+
+// MoonIlluminationPercent 返回月面照亮比例的百分数，取整
+func (s *SkyContext) MoonIlluminationPercent() int {
+	return int(math.Round(s.MoonIllumination * 100))
+}
+
+func degSin(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func degCos(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+
+// julianCentury 返回 t 距 J2000.0 的儒略世纪数
+func julianCentury(t time.Time) float64 {
+	jd := float64(t.UTC().UnixNano())/float64(24*time.Hour) + 2440587.5
+	return (jd - 2451545) / 36525
+}
+
+// solarPosition 返回太阳赤纬（度）和时差（分钟），算法取自 NOAA 太阳位置计算表
+func solarPosition(t time.Time) (declination, equationOfTime float64) {
+	T := julianCentury(t)
+	l0 := math.Mod(280.46646+T*(36000.76983+T*0.0003032), 360)
+	m := 357.52911 + T*(35999.05029-0.0001537*T)
+	e := 0.016708634 - T*(0.000042037+0.0000001267*T)
+	c := degSin(m)*(1.914602-T*(0.004817+0.000014*T)) + degSin(2*m)*(0.019993-0.000101*T) + degSin(3*m)*0.000289
+	omega := 125.04 - 1934.136*T
+	lambda := l0 + c - 0.00569 - 0.00478*degSin(omega)
+	epsilon := 23 + (26+(21.448-T*(46.815+T*(0.00059-T*0.001813)))/60)/60 + 0.00256*degCos(omega)
+	declination = math.Asin(degSin(epsilon)*degSin(lambda)) * 180 / math.Pi
+
+	y := math.Pow(math.Tan(epsilon/2*math.Pi/180), 2)
+	eot := y*degSin(2*l0) - 2*e*degSin(m) + 4*e*y*degSin(m)*degCos(2*l0) - 0.5*y*y*degSin(4*l0) - 1.25*e*e*degSin(2*m)
+	equationOfTime = 4 * eot * 180 / math.Pi
+	return declination, equationOfTime
+}
+
+// solarHourAngle 返回 t 时刻太阳的时角（度，-180 到 180，正午为 0）
+func solarHourAngle(t time.Time, lon float64) float64 {
+	_, eot := solarPosition(t)
+	u := t.UTC()
+	minutes := float64(u.Hour()*60+u.Minute()) + float64(u.Second())/60 + eot + 4*lon
+	return math.Mod(math.Mod(minutes/4-180, 360)+540, 360) - 180
+}
+
+// sunAltitude 返回 t 时刻太阳中心的几何高度角（度），不含大气折射
+func sunAltitude(t time.Time, lat, lon float64) float64 {
+	declination, _ := solarPosition(t)
+	ha := solarHourAngle(t, lon)
+	return math.Asin(degSin(lat)*degSin(declination)+degCos(lat)*degCos(declination)*degCos(ha)) * 180 / math.Pi
+}
+
+// sunTimes 返回 t 所在当地日期中太阳上升和下降经过 altitude 高度角的时刻，不发生时为 nil
+func sunTimes(t time.Time, lat, lon, altitude float64) (rising, setting *time.Time) {
+	y, mo, d := t.Date()
+	noon := time.Date(y, mo, d, 12, 0, 0, 0, t.Location())
+	// Converge on the transit nearest local clock noon
+	for i := 0; i < 3; i++ {
+		noon = noon.Add(-time.Duration(solarHourAngle(noon, lon) / 15 * float64(time.Hour)))
+	}
+	crossing := func(direction float64) *time.Time {
+		at := noon
+		for i := 0; i < 3; i++ {
+			declination, _ := solarPosition(at)
+			cosHA := (degSin(altitude) - degSin(lat)*degSin(declination)) / (degCos(lat) * degCos(declination))
+			if cosHA < -1 || cosHA > 1 {
+				return nil
+			}
+			ha := math.Acos(cosHA) * 180 / math.Pi
+			at = noon.Add(time.Duration(direction * ha / 15 * float64(time.Hour)))
+		}
+		at = at.Round(time.Minute).In(t.Location())
+		return &at
+	}
+	return crossing(-1), crossing(1)
+}
+
+// moonPhase 返回 t 时刻的月相 (0-1)，即月亮与太阳黄经差除以 360°。
+// 月亮黄经只取主要的周期项，误差约 1°，足够区分月相
+func moonPhase(t time.Time) float64 {
+	days := julianCentury(t) * 36525
+	sunMean := 357.529 + 0.98560028*days
+	sunLongitude := 280.459 + 0.98564736*days + 1.915*degSin(sunMean) + 0.020*degSin(2*sunMean)
+	moonLongitude := 218.316 + 13.176396*days
+	moonAnomaly := 134.963 + 13.064993*days
+	elongation := 297.850 + 12.190749*days
+	moonLongitude += 6.289*degSin(moonAnomaly) + 1.274*degSin(2*elongation-moonAnomaly) + 0.658*degSin(2*elongation) +
+		0.214*degSin(2*moonAnomaly) - 0.186*degSin(sunMean) - 0.114*degSin(2*(93.272+13.229350*days))
+	return math.Mod(math.Mod(moonLongitude-sunLongitude, 360)+360, 360) / 360
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// wavFormat 是 WAV fmt 块中与解码相关的字段
+type wavFormat struct {
+	AudioFormat   uint16 // 1 = 整数 PCM，3 = IEEE 浮点
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	BlockAlign    int
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+func parseWavFormat(data []byte) (wavFormat, error) {
+	if len(data) < 16 {
+		return wavFormat{}, fmt.Errorf("fmt chunk too short")
+	}
+	format := wavFormat{
+		AudioFormat:   binary.LittleEndian.Uint16(data[0:2]),
+		Channels:      int(binary.LittleEndian.Uint16(data[2:4])),
+		SampleRate:    int(binary.LittleEndian.Uint32(data[4:8])),
+		BlockAlign:    int(binary.LittleEndian.Uint16(data[12:14])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(data[14:16])),
+	}
+	// WAVE_FORMAT_EXTENSIBLE keeps the real format in the first two bytes of the sub-format GUID
+	if format.AudioFormat == wavFormatExtensible && len(data) >= 26 {
+		format.AudioFormat = binary.LittleEndian.Uint16(data[24:26])
+	}
+	if format.Channels == 0 || format.BlockAlign == 0 {
+		return wavFormat{}, fmt.Errorf("invalid fmt chunk")
+	}
+	return format, nil
+}
+
+// audioStream 把音频解码为交错的浮点样本，取值范围 [-1, 1]
+type audioStream struct {
+	Channels   int
+	SampleRate int
+	read       func(buf []float64) (int, error)
+	close      func() error
+}
+
+// Read 读取最多 len(buf) 个样本，返回的数量总是通道数的整数倍
+func (s *audioStream) Read(buf []float64) (int, error) { return s.read(buf) }
+
+func (s *audioStream) Close() error { return s.close() }
+
+// openAudioStream 打开音频文件进行解码：整数或浮点 PCM 的 WAV 直接在 Go 中读取，
+// 其他格式交给 ffmpeg 解码为 32 位浮点
+func openAudioStream(path string) (*audioStream, error) {
+	if stream, err := openWavStream(path); err == nil {
+		return stream, nil
+	}
+	return openFFmpegStream(path)
+}
+
+func openWavStream(path string) (*audioStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := listRiffChunks(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var format wavFormat
+	var data *riffChunk
+	for i, chunk := range chunks {
+		switch chunk.ID {
+		case "fmt ":
+			raw := make([]byte, chunk.Size)
+			if _, err := f.ReadAt(raw, chunk.Offset); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			if format, err = parseWavFormat(raw); err != nil {
+				f.Close()
+				return nil, err
+			}
+		case "data":
+			data = &chunks[i]
+		}
+	}
+	if data == nil || format.Channels == 0 {
+		f.Close()
+		return nil, fmt.Errorf("missing fmt or data chunk")
+	}
+	decode, err := wavSampleDecoder(format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bytesPerSample := format.BlockAlign / format.Channels
+	reader := bufio.NewReaderSize(io.NewSectionReader(f, data.Offset, data.Size), 1<<16)
+	var raw []byte
+	return &audioStream{
+		Channels:   format.Channels,
+		SampleRate: format.SampleRate,
+		read: func(buf []float64) (int, error) {
+			frames := len(buf) / format.Channels
+			need := frames * format.BlockAlign
+			if cap(raw) < need {
+				raw = make([]byte, need)
+			}
+			n, err := io.ReadFull(reader, raw[:need])
+			n -= n % format.BlockAlign
+			count := n / bytesPerSample
+			for i := 0; i < count; i++ {
+				buf[i] = decode(raw[i*bytesPerSample:])
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if count > 0 && err == io.EOF {
+				err = nil
+			}
+			return count, err
+		},
+		close: f.Close,
+	}, nil
+}
+
+// wavSampleDecoder 返回把单个样本的字节转为 [-1, 1] 浮点数的函数
+func wavSampleDecoder(format wavFormat) (func(b []byte) float64, error) {
+	switch {
+	case format.AudioFormat == wavFormatPCM && format.BitsPerSample == 8:
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case format.AudioFormat == wavFormatPCM && format.BitsPerSample == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }, nil
+	case format.AudioFormat == wavFormatPCM && format.BitsPerSample == 24:
+		return func(b []byte) float64 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float64(v) / 8388608
+		}, nil
+	case format.AudioFormat == wavFormatPCM && format.BitsPerSample == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648 }, nil
+	case format.AudioFormat == wavFormatFloat && format.BitsPerSample == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	case format.AudioFormat == wavFormatFloat && format.BitsPerSample == 64:
+		return func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported WAV sample format %d with %d bits", format.AudioFormat, format.BitsPerSample)
+}
+
+func openFFmpegStream(path string) (*audioStream, error) {
+	_, sampleRate, _, channels, err := getAudioTechInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	if channels == 0 {
+		channels = 2
+	}
+	cmd := exec.Command("ffmpeg", "-v", "error", "-i", path, "-ac", strconv.Itoa(channels), "-f", "f32le", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ffmpeg stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg for %s: %w", path, err)
+	}
+	reader := bufio.NewReaderSize(stdout, 1<<16)
+	var raw []byte
+	return &audioStream{
+		Channels:   channels,
+		SampleRate: sampleRate,
+		read: func(buf []float64) (int, error) {
+			need := (len(buf) / channels) * channels * 4
+			if cap(raw) < need {
+				raw = make([]byte, need)
+			}
+			n, err := io.ReadFull(reader, raw[:need])
+			count := n / 4
+			count -= count % channels
+			for i := 0; i < count; i++ {
+				buf[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])))
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if count > 0 && err == io.EOF {
+				err = nil
+			}
+			return count, err
+		},
+		close: func() error {
+			stdout.Close()
+			if err := cmd.Wait(); err != nil {
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					return fmt.Errorf("ffmpeg %w, stderr: %s", err, msg)
+				}
+				return fmt.Errorf("ffmpeg %w", err)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// forEachAudioBlock 解码整个文件，每次回调约 blockSeconds 秒的交错样本。
+// ffmpeg 中途失败时输出只会提前结束，因此关闭时的错误也会返回，调用方不应保存不完整的结果
+func forEachAudioBlock(path string, blockSeconds float64, fn func(samples []float64, channels, sampleRate int)) (err error) {
+	stream, err := openAudioStream(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for decoding: %w", path, err)
+	}
+	defer func() {
+		if cerr := stream.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to decode %s: %w", path, cerr)
+		}
+	}()
+	frames := int(float64(stream.SampleRate) * blockSeconds)
+	if frames <= 0 {
+		frames = 4096
+	}
+	buf := make([]float64, frames*stream.Channels)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			fn(buf[:n], stream.Channels, stream.SampleRate)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const bextMinSize = 602 // bext 块中 CodingHistory 之前的固定长度
+
+// buildBext 在原有 bext 块的基础上写入描述、录音机和录音时间，其余字段（UMID、响度、
+// CodingHistory 等）保持不变。没有 bext 块时新建一个版本 1 的块。录音时间改变时按新时间重新计算
+// TimeReference，使其与 OriginationDate/Time 一致；录音机为空时保留原有的 Originator
+func buildBext(existing []byte, meta AudioMetadata) []byte {
+	var buf []byte
+	if len(existing) >= bextMinSize {
+		buf = append([]byte{}, existing...)
+	} else {
+		buf = make([]byte, bextMinSize)
+		binary.LittleEndian.PutUint16(buf[346:348], 1)
+	}
+	putFixedString(buf[0:256], meta.Description)
+	if meta.Recorder != "" {
+		putFixedString(buf[256:288], meta.Recorder)
+	}
+	if !meta.RecordDate.IsZero() {
+		t := meta.RecordDate
+		date, clock := t.Format("2006-01-02"), t.Format("15:04:05")
+		// An unchanged time keeps the recorder's own sample-accurate TimeReference. EBU Tech 3285 allows
+		// several separators, so only the digits are compared
+		if bextDigits(buf[320:338]) != bextDigits([]byte(date+clock)) {
+			if meta.TechInfo.SampleRate > 0 {
+				secondsSinceMidnight := uint64(t.Hour()*3600 + t.Minute()*60 + t.Second())
+				binary.LittleEndian.PutUint64(buf[338:346], secondsSinceMidnight*uint64(meta.TechInfo.SampleRate))
+			}
+			putFixedString(buf[320:330], date)
+			putFixedString(buf[330:338], clock)
+		}
+	}
+	return buf
+}
+
+// bextDigits 返回 OriginationDate/Time 字段中的数字
+func bextDigits(field []byte) string {
+	var digits []byte
+	for _, c := range field {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, c)
+		}
+	}
+	return string(digits)
+}
+
+// putFixedString 把 s 写入定长字段，按 UTF-8 字符边界截断，剩余部分补 NUL
+func putFixedString(field []byte, s string) {
+	for len(s) > len(field) {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	n := copy(field, s)
+	for i := n; i < len(field); i++ {
+		field[i] = 0
+	}
+}
+
+// buildInfoList 生成 LIST-INFO 块的内容（以 "INFO" 开头），保留原有块中不由这里管理的字段
+func buildInfoList(existing []byte, meta AudioMetadata) []byte {
+	values := map[string]string{
+		"INAM": meta.Title,
+		"ICMT": meta.Description,
+		"IART": meta.Artist,
+		"ICOP": meta.License,
+	}
+	if !meta.RecordDate.IsZero() {
+		values["ICRD"] = meta.RecordDate.Format("2006-01-02")
+	}
+	order := []string{"INAM", "ICMT", "IART", "ICRD", "ICOP"}
+
+	var b bytes.Buffer
+	b.WriteString("INFO")
+	writeSub := func(id, value string) {
+		data := append([]byte(value), 0)
+		b.WriteString(id)
+		binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+		b.Write(data)
+		if len(data)%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+	// Keep subchunks we don't manage, e.g. ISFT written by the recorder
+	for offset := 4; offset+8 <= len(existing); {
+		id := string(existing[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(existing[offset+4 : offset+8]))
+		end := offset + 8 + size
+		if end > len(existing) {
+			break
+		}
+		if !containsString(order, id) {
+			writeSub(id, strings.TrimRight(string(existing[offset+8:end]), "\x00"))
+		}
+		offset = end + size%2
+	}
+	for _, id := range order {
+		if v := strings.TrimSpace(values[id]); v != "" {
+			writeSub(id, v)
+		}
+	}
+	return b.Bytes()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ixmlNoteRe     = regexp.MustCompile(`(?s)<NOTE>.*?</NOTE>\s*`)
+	ixmlLocationRe = regexp.MustCompile(`(?s)<LOCATION>.*?</LOCATION>\s*`)
+)
+
+// buildIXML 在原有 iXML 中替换 NOTE 和 LOCATION，其余内容（例如 TRACK_LIST）原样保留
+func buildIXML(existing []byte, meta AudioMetadata) []byte {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var elements strings.Builder
+	if meta.Description != "" {
+		fmt.Fprintf(&elements, "<NOTE>%s</NOTE>", escape(meta.Description))
+	}
+	if meta.Location != "" || meta.Latitude != nil {
+		elements.WriteString("<LOCATION>")
+		if meta.Location != "" {
+			fmt.Fprintf(&elements, "<LOCATION_NAME>%s</LOCATION_NAME>", escape(meta.Location))
+		}
+		if meta.Latitude != nil && meta.Longitude != nil {
+			fmt.Fprintf(&elements, "<LOCATION_GPS>%.6f, %.6f</LOCATION_GPS>", *meta.Latitude, *meta.Longitude)
+		}
+		elements.WriteString("</LOCATION>")
+	}
+
+	doc := strings.TrimRight(string(existing), "\x00")
+	if !strings.Contains(doc, "</BWFXML>") {
+		doc = `<?xml version="1.0" encoding="UTF-8"?>` + "\n<BWFXML><IXML_VERSION>2.10</IXML_VERSION></BWFXML>"
+	}
+	doc = ixmlNoteRe.ReplaceAllString(doc, "")
+	doc = ixmlLocationRe.ReplaceAllString(doc, "")
+	i := strings.LastIndex(doc, "</BWFXML>")
+	return []byte(doc[:i] + elements.String() + doc[i:])
+}
+
+// isInfoList 判断块是否为 LIST-INFO（LIST 也用于 adtl 等其他用途）
+func isInfoList(f io.ReaderAt, chunk riffChunk) bool {
+	if chunk.ID != "LIST" || chunk.Size < 4 {
+		return false
+	}
+	listType := make([]byte, 4)
+	_, err := f.ReadAt(listType, chunk.Offset)
+	return err == nil && string(listType) == "INFO"
+}
+
+// writeBWFMetadata 把元数据写入 WAV 的 bext、LIST-INFO 和 iXML 块。
+// 新文件先写入临时文件，确认 fmt 和 data 块与原文件逐字节相同后再替换原文件，PCM 数据不会被修改
+func writeBWFMetadata(path string, meta AudioMetadata) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	chunks, err := listRiffChunks(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	header := make([]byte, 12)
+	if _, err := src.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	readChunk := func(chunk riffChunk) ([]byte, error) {
+		data := make([]byte, chunk.Size)
+		_, err := src.ReadAt(data, chunk.Offset)
+		return data, err
+	}
+
+	existing := map[string][]byte{}
+	for _, chunk := range chunks {
+		id := chunk.ID
+		if isInfoList(src, chunk) {
+			id = "INFO"
+		} else if id != "bext" && id != "iXML" {
+			continue
+		}
+		if _, seen := existing[id]; seen {
+			continue
+		}
+		if existing[id], err = readChunk(chunk); err != nil {
+			return fmt.Errorf("failed to read %s chunk: %w", id, err)
+		}
+	}
+	replacements := []struct {
+		id   string
+		data []byte
+	}{
+		{"bext", buildBext(existing["bext"], meta)},
+		{"LIST", buildInfoList(existing["INFO"], meta)},
+		{"iXML", buildIXML(existing["iXML"], meta)},
+	}
+
+	tmpPath := path + transcodeTempSuffix
+	defer os.Remove(tmpPath) // No-op once the file has been renamed into place
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	defer dst.Close()
+
+	var written int64
+	write := func(b []byte) error {
+		n, err := dst.Write(b)
+		written += int64(n)
+		return err
+	}
+	writeChunk := func(id string, data []byte) error {
+		chunkHeader := make([]byte, 8)
+		copy(chunkHeader, id)
+		binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(len(data)))
+		if err := write(chunkHeader); err != nil {
+			return err
+		}
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+		return write(data)
+	}
+	metadataWritten := false
+	writeMetadata := func() error {
+		if metadataWritten {
+			return nil
+		}
+		metadataWritten = true
+		for _, r := range replacements {
+			if err := writeChunk(r.id, r.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := write(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	ds64Offset := int64(-1)
+	for _, chunk := range chunks {
+		if chunk.ID == "bext" || chunk.ID == "iXML" || isInfoList(src, chunk) {
+			continue
+		}
+		// New metadata goes right before the audio data, as most recorders do
+		if chunk.ID == "data" {
+			if err := writeMetadata(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+			}
+		}
+		if chunk.ID == "ds64" {
+			ds64Offset = written + 8
+		}
+		// Copy the original chunk header verbatim, RF64 data sizes live in ds64
+		if _, err := io.Copy(dst, io.NewSectionReader(src, chunk.Offset-8, 8+chunk.Size)); err != nil {
+			return fmt.Errorf("failed to copy %s chunk: %w", chunk.ID, err)
+		}
+		written += 8 + chunk.Size
+		if chunk.Size%2 == 1 {
+			if err := write([]byte{0}); err != nil {
+				return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+			}
+		}
+	}
+	if err := writeMetadata(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+
+	// Patch the RIFF size, or the ds64 RIFF size for RF64 files
+	riffSize := make([]byte, 8)
+	if string(header[0:4]) == "RF64" {
+		if ds64Offset < 0 {
+			return fmt.Errorf("RF64 file %s has no ds64 chunk", path)
+		}
+		binary.LittleEndian.PutUint64(riffSize, uint64(written-8))
+		_, err = dst.WriteAt(riffSize, ds64Offset)
+	} else {
+		if written-8 > 0xFFFFFFFF {
+			return fmt.Errorf("%s would exceed the 4 GB RIFF limit", path)
+		}
+		binary.LittleEndian.PutUint32(riffSize, uint32(written-8))
+		_, err = dst.WriteAt(riffSize[:4], 4)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update RIFF size: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := verifyAudioChunksUnchanged(path, tmpPath); err != nil {
+		return err
+	}
+	bext, err := readBext(tmpPath)
+	if err != nil || bext == nil {
+		return fmt.Errorf("written bext chunk can't be read back: %v", err)
+	}
+	if err := os.Chtimes(tmpPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("failed to preserve modification time: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", tmpPath, err)
+	}
+	return nil
+}
+
+// verifyAudioChunksUnchanged 确认两个 WAV 文件的 fmt 和 data 块内容相同
+func verifyAudioChunksUnchanged(originalPath, newPath string) error {
+	for _, id := range []string{"fmt ", "data"} {
+		a, err := hashRiffChunk(originalPath, id)
+		if err != nil {
+			return err
+		}
+		b, err := hashRiffChunk(newPath, id)
+		if err != nil {
+			return err
+		}
+		if a != b {
+			return fmt.Errorf("%s chunk changed while rewriting metadata", strings.TrimSpace(id))
+		}
+	}
+	return nil
+}
+
+// hashRiffChunk 计算 WAV 文件中第一个 id 块内容的 SHA-256，不把整个块读入内存
+func hashRiffChunk(path, id string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	chunks, err := listRiffChunks(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, chunk := range chunks {
+		if chunk.ID != id {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, chunk.Offset, chunk.Size)); err != nil {
+			return "", fmt.Errorf("failed to read %s chunk of %s: %w", id, path, err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return "", fmt.Errorf("%s has no %s chunk", path, strings.TrimSpace(id))
+}
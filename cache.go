@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// cacheManifestFilename 是缓存目录下记录转码来源的清单文件
+const cacheManifestFilename = "cache_manifest.json"
+
+// transcodeTempSuffix 标记尚未校验完成的转码输出，残留的此类文件会在下次生成时清理
+const transcodeTempSuffix = ".partial"
+
+var (
+	ffmpegVersionOnce  sync.Once
+	ffmpegVersionCache string
+)
+
+// CacheEntry 记录一个缓存文件由哪个源文件内容、哪种编码配置生成
+type CacheEntry struct {
+	SourceHash         string `json:"source_hash"`
+	EncoderFingerprint string `json:"encoder_fingerprint"`
+}
+
+// CacheManifest 以缓存文件相对路径为键，记录每个缓存文件的来源
+type CacheManifest struct {
+	Entries map[string]CacheEntry `json:"entries"`
+	dir     string
+}
+
+func (m *CacheManifest) path() string {
+	return filepath.Join(m.dir, cacheManifestFilename)
+}
+
+// loadCacheManifest 读取 dir 缓存目录的清单，文件不存在时返回空清单
+func loadCacheManifest(dir string) (*CacheManifest, error) {
+	manifest := &CacheManifest{Entries: make(map[string]CacheEntry), dir: dir}
+	content, err := os.ReadFile(manifest.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("failed to read cache manifest: %w", err)
+	}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cache manifest: %w", err)
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = make(map[string]CacheEntry)
+	}
+	return manifest, nil
+}
+
+func (m *CacheManifest) save() error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache manifest: %w", err)
+	}
+	if err := os.WriteFile(m.path(), content, 0644); err != nil {
+		return fmt.Errorf("failed to write cache manifest: %w", err)
+	}
+	return nil
+}
+
+// isFresh 判断缓存是否仍对应当前的源文件内容和编码配置
+func (m *CacheManifest) isFresh(relPath, sourceHash, fingerprint string) bool {
+	entry, ok := m.Entries[filepath.ToSlash(relPath)]
+	return ok && entry.SourceHash == sourceHash && entry.EncoderFingerprint == fingerprint
+}
+
+func (m *CacheManifest) record(relPath, sourceHash, fingerprint string) {
+	m.Entries[filepath.ToSlash(relPath)] = CacheEntry{SourceHash: sourceHash, EncoderFingerprint: fingerprint}
+}
+
+func (m *CacheManifest) rename(oldRelPath, newRelPath string) {
+	oldKey := filepath.ToSlash(oldRelPath)
+	if entry, ok := m.Entries[oldKey]; ok {
+		delete(m.Entries, oldKey)
+		m.Entries[filepath.ToSlash(newRelPath)] = entry
+	}
+}
+
+func (m *CacheManifest) remove(relPath string) {
+	delete(m.Entries, filepath.ToSlash(relPath))
+}
+
+// updateCacheManifest 加载清单，应用修改后写回，供重命名/删除等管理操作使用
+func updateCacheManifest(dir string, update func(m *CacheManifest)) error {
+	manifest, err := loadCacheManifest(dir)
+	if err != nil {
+		return err
+	}
+	update(manifest)
+	return manifest.save()
+}
+
+// hashFile 计算文件内容的 SHA-256
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for hashing: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSourceFiles 计算录音源文件的哈希；分段录音为各段哈希按顺序拼接后的哈希，单个文件与 hashFile 相同
+func hashSourceFiles(paths []string) (string, error) {
+	if len(paths) == 1 {
+		return hashFile(paths[0])
+	}
+	h := sha256.New()
+	for _, path := range paths {
+		partHash, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, partHash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// refreshSourceHash 只在源文件大小或修改时间变化时重新计算哈希，并同时更新 SourceHash 和 SourceStat。
+// 返回 true 表示两者已更新
+func refreshSourceHash(metadata *AudioMetadata) (bool, error) {
+	paths := metadata.SourcePaths()
+	stat, err := sourceStat(paths)
+	if err != nil {
+		return false, err
+	}
+	if metadata.SourceHash != "" && metadata.SourceStat == stat {
+		return false, nil
+	}
+	hash, err := hashSourceFiles(paths)
+	if err != nil {
+		return false, err
+	}
+	metadata.SourceHash = hash
+	metadata.SourceStat = stat
+	return true, nil
+}
+
+// sourceStat 记录源文件的大小与修改时间，用于判断是否需要重新计算哈希
+func sourceStat(paths []string) (string, error) {
+	stats := make([]string, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stats = append(stats, fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(stats, ";"), nil
+}
+
+// ffmpegVersion 返回 `ffmpeg -version` 的首行，结果在进程内缓存
+func ffmpegVersion() string {
+	ffmpegVersionOnce.Do(func() {
+		stdout, _, err := runCommand("ffmpeg", "-version")
+		if err != nil {
+			ffmpegVersionCache = "unknown"
+			return
+		}
+		line, _, _ := strings.Cut(stdout, "\n")
+		// Drop the copyright notice, it carries no information about the encoder
+		line, _, _ = strings.Cut(line, " Copyright")
+		ffmpegVersionCache = strings.TrimSpace(line)
+	})
+	return ffmpegVersionCache
+}
+
+// encoderFingerprint 由编码参数和 ffmpeg 版本生成指纹
+func encoderFingerprint(args []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s", ffmpegVersion(), strings.Join(args, "\x00"))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// cleanStaleTranscodes 删除 dir 中上次生成中断后残留的临时转码文件。
+// 由于清单只在转码成功后写入，对应的录音会在本次生成中重新转码。
+func cleanStaleTranscodes(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasSuffix(info.Name(), transcodeTempSuffix) {
+			log.Printf("Removing stale partial transcode: %s", path)
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Warning: Failed to remove stale partial transcode %s: %v", path, err)
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// aacEncoderArgs 是转码 AAC 时使用的编码参数，修改后会触发重新转码。
+// 缓存不保留源文件的标签（可能含有准确位置），发布时由 publishTagged 按隐私设置重新写入
+var aacEncoderArgs = []string{"-vn", "-map_metadata", "-1", "-c:a", "aac", "-vbr", "4"}
+
+// encodeJob 描述一次 ffmpeg 转码，所有参数都参与缓存指纹的计算
+type encodeJob struct {
+	InputArgs        []string // 放在 -i 之前的参数，例如裁剪点
+	OutputArgs       []string // 滤镜与编码器参数
+	ExpectedDuration float64  // 输出应有的时长，用于校验转码结果
+	CoverPath        string   // 作为第二个输入的封面图片，不参与指纹
+}
+
+func (j encodeJob) args(inputPath, outputPath string) []string {
+	args := append([]string{"-y"}, j.InputArgs...)
+	args = append(args, "-i", inputPath)
+	if j.CoverPath != "" {
+		args = append(args, "-i", j.CoverPath)
+	}
+	args = append(args, j.OutputArgs...)
+	return append(args, outputPath)
+}
+
+func (j encodeJob) fingerprint() string {
+	return encoderFingerprint(append(append([]string{}, j.InputArgs...), j.OutputArgs...))
+}
+
+// newAacEncodeJob 根据录音的元数据和全局设置生成 AAC 转码任务
+func newAacEncodeJob(meta AudioMetadata, settings Settings) encodeJob {
+	job := encodeJob{ExpectedDuration: meta.Edit.outputDuration(meta.DurationSeconds)}
+	job.InputArgs = meta.Edit.inputArgs()
+	if len(meta.SplitParts) > 1 {
+		// The input is a list of the parts, see writeConcatList
+		job.InputArgs = append(job.InputArgs, concatDemuxerArgs...)
+	}
+	var filters []string
+	if f, err := channelMixFilter(meta.ChannelMix, meta.TechInfo.Channels, settings.Downmix); err != nil {
+		log.Printf("Warning: %s: %v. Falling back to a standard stereo downmix.", meta.SourceFilename, err)
+		filters = append(filters, stereoDownmixFilter)
+	} else if f != "" {
+		filters = append(filters, f)
+	}
+	if f := meta.Edit.fadeFilter(job.ExpectedDuration); f != "" {
+		filters = append(filters, f)
+	}
+	if len(filters) > 0 {
+		job.OutputArgs = append(job.OutputArgs, "-af", strings.Join(filters, ","))
+	}
+	job.OutputArgs = append(job.OutputArgs, aacEncoderArgs...)
+	return job
+}
+
+// ChannelMix 的取值
+const (
+	channelMixStereo      = "stereo"       // ffmpeg 标准缩混
+	channelMixAmbiXStereo = "ambix-stereo" // 一阶 AmbiX (ACN/SN3D) B-format 解码为左右两个虚拟心形指向
+	channelMixBinaural    = "binaural"     // 用 SOFA HRTF 渲染为双耳
+	channelMixPairPrefix  = "pair:"        // "pair:3-4" 表示只发布第 3、4 通道（从 1 开始）
+)
+
+// stereoDownmixFilter 让 ffmpeg 以标准矩阵把任意布局转为立体声
+const stereoDownmixFilter = "aformat=channel_layouts=stereo"
+
+// channelMixFilter 返回把源通道转为发布用立体声的滤镜。
+// mode 为空时，单声道和立体声保持原样，多通道使用 downmix 中的默认方式。
+func channelMixFilter(mode string, channels int, downmix DownmixSettings) (string, error) {
+	if mode == "" {
+		if channels <= 2 {
+			return "", nil
+		}
+		mode = downmix.Mode
+		if mode == "" {
+			mode = channelMixStereo
+		}
+	}
+	switch {
+	case mode == channelMixStereo:
+		return stereoDownmixFilter, nil
+	case mode == channelMixAmbiXStereo:
+		if channels < 4 {
+			return "", fmt.Errorf("ambisonic decode needs 4 channels, got %d", channels)
+		}
+		// W is ACN 0 and Y (left-right) is ACN 1
+		return "pan=stereo|c0=0.5*c0+0.5*c1|c1=0.5*c0-0.5*c1", nil
+	case mode == channelMixBinaural:
+		if downmix.SofaPath == "" {
+			return "", fmt.Errorf("binaural downmix needs downmix.sofa_path in settings.json")
+		}
+		return fmt.Sprintf("sofalizer=sofa='%s',%s", strings.ReplaceAll(downmix.SofaPath, "'", `'\''`), stereoDownmixFilter), nil
+	case strings.HasPrefix(mode, channelMixPairPrefix):
+		var left, right int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(mode, channelMixPairPrefix), "%d-%d", &left, &right); err != nil {
+			return "", fmt.Errorf("invalid channel pair %q", mode)
+		}
+		if left < 1 || right < 1 || (channels > 0 && (left > channels || right > channels)) {
+			return "", fmt.Errorf("channel pair %q is out of range for %d channels", mode, channels)
+		}
+		return fmt.Sprintf("pan=stereo|c0=c%d|c1=c%d", left-1, right-1), nil
+	}
+	return "", fmt.Errorf("unknown channel mix %q", mode)
+}
+
+// channelMixOptions 列出编辑页中可选的通道处理方式，names 为 iXML 中的通道名称
+func channelMixOptions(channels int, names []string) []ChannelMixOption {
+	options := []ChannelMixOption{
+		{Value: "", Label: "默认（多通道按全局设置缩混）"},
+		{Value: channelMixStereo, Label: "标准立体声缩混"},
+	}
+	if channels <= 2 {
+		return options
+	}
+	if channels >= 4 {
+		options = append(options, ChannelMixOption{Value: channelMixAmbiXStereo, Label: "AmbiX B-format 解码为立体声"})
+	}
+	options = append(options, ChannelMixOption{Value: channelMixBinaural, Label: "双耳渲染 (HRTF)"})
+	channelName := func(i int) string {
+		if i <= len(names) && names[i-1] != "" {
+			return fmt.Sprintf("%d (%s)", i, names[i-1])
+		}
+		return fmt.Sprintf("%d", i)
+	}
+	for left := 1; left <= channels; left++ {
+		for right := left + 1; right <= channels; right++ {
+			options = append(options, ChannelMixOption{
+				Value: fmt.Sprintf("%s%d-%d", channelMixPairPrefix, left, right),
+				Label: fmt.Sprintf("仅通道 %s + %s", channelName(left), channelName(right)),
+			})
+		}
+	}
+	return options
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// outputDuration 返回应用裁剪点后的时长，sourceDuration 未知时原样返回
+func (e EditPoints) outputDuration(sourceDuration float64) float64 {
+	if sourceDuration <= 0 {
+		return sourceDuration
+	}
+	end := sourceDuration
+	if e.TrimOutSeconds > 0 && e.TrimOutSeconds < end {
+		end = e.TrimOutSeconds
+	}
+	start := math.Min(math.Max(e.TrimInSeconds, 0), end)
+	return end - start
+}
+
+func (e EditPoints) inputArgs() []string {
+	var args []string
+	if e.TrimInSeconds > 0 {
+		args = append(args, "-ss", formatSeconds(e.TrimInSeconds))
+	}
+	if e.TrimOutSeconds > 0 {
+		args = append(args, "-to", formatSeconds(e.TrimOutSeconds))
+	}
+	return args
+}
+
+// fadeFilter 生成 afade 滤镜；输入端 -ss 会把时间戳归零，所以淡出位置以裁剪后的时长计算
+func (e EditPoints) fadeFilter(duration float64) string {
+	var filters []string
+	if e.FadeInSeconds > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:st=0:d=%s", formatSeconds(e.FadeInSeconds)))
+	}
+	if e.FadeOutSeconds > 0 && duration > 0 {
+		start := math.Max(duration-e.FadeOutSeconds, 0)
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%s:d=%s", formatSeconds(start), formatSeconds(e.FadeOutSeconds)))
+	}
+	return strings.Join(filters, ",")
+}
+
+// normalize 修正明显无效的输入：负数归零，出点早于入点时忽略出点
+func (e EditPoints) normalize() EditPoints {
+	e.TrimInSeconds = math.Max(e.TrimInSeconds, 0)
+	e.TrimOutSeconds = math.Max(e.TrimOutSeconds, 0)
+	e.FadeInSeconds = math.Max(e.FadeInSeconds, 0)
+	e.FadeOutSeconds = math.Max(e.FadeOutSeconds, 0)
+	if e.TrimOutSeconds > 0 && e.TrimOutSeconds <= e.TrimInSeconds {
+		e.TrimOutSeconds = 0
+	}
+	return e
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"path"
+	"sort"
+)
+
+const (
+	eventFFTSize           = 1024
+	eventBackgroundSeconds = 60.0 // 估计背景电平时使用的滑动窗口长度
+	eventBackgroundBlock   = 5.0  // 背景电平每隔该时长更新一次
+	eventBackgroundPercent = 20.0 // 窗口内该百分位的帧能量视为背景
+	eventOnsetFrames       = 5    // 起始检测与前面几帧的平均能量比较
+	eventMaxCount          = 300  // 每条录音最多保留的事件数，超出时保留最响的
+)
+
+// EventSettings 定义自动事件检测的阈值。Folders 按相对 wav 目录的文件夹路径覆盖默认值，
+// 子文件夹继承最近的上级设置，只需填写要改变的项
+type EventSettings struct {
+	EventThresholds
+	Folders map[string]EventThresholds `json:"folders"`
+}
+
+// EventThresholds 是一组检测阈值，数值为 0 时使用默认值
+type EventThresholds struct {
+	Disabled           *bool    `json:"disabled,omitempty"`   // 为空时沿用上级设置，子文件夹可以设为 false 重新启用
+	EnergyDB           float64  `json:"energy_db"`            // 频带能量高出背景电平多少 dB 视为事件，默认 10
+	OnsetDB            float64  `json:"onset_db"`             // 能量相对前 5 帧突增多少 dB 视为起始，默认 6
+	Novelty            float64  `json:"novelty"`              // 频带内频谱通量达到中位数的多少倍视为新奇，默认 4
+	Band               FreqBand `json:"band"`                 // 检测使用的频带，默认 500-12000 Hz
+	MinDurationSeconds float64  `json:"min_duration_seconds"` // 默认 0.1
+	MergeGapSeconds    float64  `json:"merge_gap_seconds"`    // 间隔短于该时长的事件合并为一个，默认 0.5
+}
+
+// overlay 用 o 中已设置的项覆盖 t
+func (t EventThresholds) overlay(o EventThresholds) EventThresholds {
+	if o.Disabled != nil {
+		t.Disabled = o.Disabled
+	}
+	if o.EnergyDB > 0 {
+		t.EnergyDB = o.EnergyDB
+	}
+	if o.OnsetDB > 0 {
+		t.OnsetDB = o.OnsetDB
+	}
+	if o.Novelty > 0 {
+		t.Novelty = o.Novelty
+	}
+	if o.Band.Max > o.Band.Min {
+		t.Band = o.Band
+	}
+	if o.MinDurationSeconds > 0 {
+		t.MinDurationSeconds = o.MinDurationSeconds
+	}
+	if o.MergeGapSeconds > 0 {
+		t.MergeGapSeconds = o.MergeGapSeconds
+	}
+	return t
+}
+
+func (t EventThresholds) withDefaults() EventThresholds {
+	return EventThresholds{
+		EnergyDB:           10,
+		OnsetDB:            6,
+		Novelty:            4,
+		Band:               FreqBand{Min: 500, Max: 12000},
+		MinDurationSeconds: 0.1,
+		MergeGapSeconds:    0.5,
+	}.overlay(t)
+}
+
+// forFolder 返回 folder（相对 wav 目录，"." 表示根目录）适用的阈值
+func (s EventSettings) forFolder(folder string) EventThresholds {
+	var chain []EventThresholds
+	for dir := path.Clean(folder); ; dir = path.Dir(dir) {
+		if t, ok := s.Folders[dir]; ok {
+			chain = append(chain, t)
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+	}
+	t := s.EventThresholds
+	for i := len(chain) - 1; i >= 0; i-- {
+		t = t.overlay(chain[i])
+	}
+	return t.withDefaults()
+}
+
+// disabled 判断是否关闭检测
+func (t EventThresholds) disabled() bool {
+	return t.Disabled != nil && *t.Disabled
+}
+
+func (t EventThresholds) configKey() string {
+	// Only detection parameters count, and a pointer would print as an address
+	t.Disabled = nil
+	return fmt.Sprintf("%+v", t)
+}
+
+// EventDetection 是自动事件检测的结果
+type EventDetection struct {
+	SourceHash string          `json:"source_hash"`
+	ConfigKey  string          `json:"config_key"`
+	Events     []DetectedEvent `json:"events"`
+}
+
+// DetectedEvent 是检测到的候选事件，时间相对源文件开头
+type DetectedEvent struct {
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds"`
+	PeakSeconds  float64 `json:"peak_seconds"`
+	PeakDBFS     float64 `json:"peak_dbfs"` // 检测频带内的峰值帧能量
+	Status       string  `json:"status,omitempty"`
+}
+
+const (
+	eventAccepted = "accepted" // 已转为手动标记
+	eventRejected = "rejected"
+)
+
+// Pending 返回尚未审核的事件数
+func (d *EventDetection) Pending() int {
+	if d == nil {
+		return 0
+	}
+	n := 0
+	for _, e := range d.Events {
+		if e.Status == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// keepReviews 把旧结果中的审核状态沿用到起止时间重叠的新事件上，调整阈值后不必重新审核
+func (d *EventDetection) keepReviews(old *EventDetection) {
+	if old == nil {
+		return
+	}
+	for i := range d.Events {
+		for _, o := range old.Events {
+			if o.Status != "" && o.StartSeconds < d.Events[i].EndSeconds && d.Events[i].StartSeconds < o.EndSeconds {
+				d.Events[i].Status = o.Status
+				break
+			}
+		}
+	}
+}
+
+// eventAnalyzer 逐帧记录检测频带内的能量和频谱通量，在 finish 中根据整条录音的背景电平判定事件
+type eventAnalyzer struct {
+	cfg        EventThresholds
+	sampleRate int
+	window     []float64
+	windowGain float64
+	frame      []float64
+	filled     int
+	buf        []complex128
+	lowBin     int
+	highBin    int
+	prevLog    []float64
+	energyDB   []float32 // 每帧频带能量 (dBFS)
+	flux       []float32 // 每帧频带内的正向频谱通量
+}
+
+func newEventAnalyzer(cfg EventThresholds) *eventAnalyzer {
+	a := &eventAnalyzer{
+		cfg:    cfg,
+		window: make([]float64, eventFFTSize),
+		frame:  make([]float64, eventFFTSize),
+		buf:    make([]complex128, eventFFTSize),
+	}
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(eventFFTSize-1))
+		a.windowGain += a.window[i]
+	}
+	return a
+}
+
+func (a *eventAnalyzer) frameSeconds() float64 {
+	return float64(eventFFTSize) / float64(a.sampleRate)
+}
+
+func (a *eventAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if a.sampleRate == 0 {
+		a.sampleRate = sampleRate
+		binHz := float64(sampleRate) / eventFFTSize
+		a.lowBin = int(math.Max(math.Ceil(a.cfg.Band.Min/binHz), 1))
+		a.highBin = int(math.Min(math.Floor(a.cfg.Band.Max/binHz), eventFFTSize/2-1))
+		if a.highBin < a.lowBin {
+			a.highBin = a.lowBin
+		}
+		a.prevLog = make([]float64, a.highBin-a.lowBin+1)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var mono float64
+		for c := 0; c < channels; c++ {
+			mono += samples[i+c]
+		}
+		a.frame[a.filled] = mono / float64(channels)
+		a.filled++
+		if a.filled == eventFFTSize {
+			a.processFrame()
+			a.filled = 0
+		}
+	}
+}
+
+func (a *eventAnalyzer) processFrame() {
+	for i, x := range a.frame {
+		a.buf[i] = complex(x*a.window[i], 0)
+	}
+	fft(a.buf)
+	scale := 2 / a.windowGain
+	var energy, flux float64
+	for k := a.lowBin; k <= a.highBin; k++ {
+		m := cmplx.Abs(a.buf[k]) * scale
+		p := m * m
+		energy += p
+		logP := math.Log10(p + 1e-12)
+		if len(a.energyDB) > 0 {
+			flux += math.Max(logP-a.prevLog[k-a.lowBin], 0)
+		}
+		a.prevLog[k-a.lowBin] = logP
+	}
+	// A full-scale sine inside the band reads 0 dBFS
+	a.energyDB = append(a.energyDB, float32(10*math.Log10(energy+1e-20)))
+	a.flux = append(a.flux, float32(flux/float64(a.highBin-a.lowBin+1)))
+}
+
+// background 返回每帧的背景电平：以该帧为中心的滑动窗口内帧能量的低百分位
+func (a *eventAnalyzer) background() []float64 {
+	n := len(a.energyDB)
+	block := int(math.Max(eventBackgroundBlock/a.frameSeconds(), 1))
+	half := int(eventBackgroundSeconds / a.frameSeconds() / 2)
+	result := make([]float64, n)
+	window := make([]float64, 0, 2*half+block)
+	for start := 0; start < n; start += block {
+		lo, hi := max(start+block/2-half, 0), min(start+block/2+half, n)
+		window = window[:0]
+		for _, e := range a.energyDB[lo:hi] {
+			window = append(window, float64(e))
+		}
+		sort.Float64s(window)
+		level := window[int(float64(len(window)-1)*eventBackgroundPercent/100)]
+		for i := start; i < min(start+block, n); i++ {
+			result[i] = level
+		}
+	}
+	return result
+}
+
+func (a *eventAnalyzer) finish() (*EventDetection, error) {
+	n := len(a.energyDB)
+	if n < 2 {
+		return nil, fmt.Errorf("recording is too short for event detection")
+	}
+	background := a.background()
+	sortedFlux := make([]float64, n)
+	for i, f := range a.flux {
+		sortedFlux[i] = float64(f)
+	}
+	sort.Float64s(sortedFlux)
+	noveltyLevel := math.Max(sortedFlux[n/2], 1e-6) * a.cfg.Novelty
+	frameSeconds := a.frameSeconds()
+
+	// An event opens on loud frames or on sudden, spectrally novel onsets, and stays open
+	// while the frame is still half the energy threshold above the background
+	var events []DetectedEvent
+	var current *DetectedEvent
+	lastActive := 0
+	for i := 0; i < n; i++ {
+		energy := float64(a.energyDB[i])
+		excess := energy - background[i]
+		var recent float64
+		for j := max(i-eventOnsetFrames, 0); j < i; j++ {
+			recent += math.Pow(10, float64(a.energyDB[j])/10)
+		}
+		onset := i > 0 && energy-10*math.Log10(recent/float64(min(i, eventOnsetFrames))+1e-20) >= a.cfg.OnsetDB &&
+			float64(a.flux[i]) >= noveltyLevel
+		open := excess >= a.cfg.EnergyDB || onset
+		sustain := excess >= a.cfg.EnergyDB/2 || float64(a.flux[i]) >= noveltyLevel
+
+		t := float64(i) * frameSeconds
+		if current == nil && open {
+			events = append(events, DetectedEvent{StartSeconds: t, PeakSeconds: t, PeakDBFS: energy})
+			current = &events[len(events)-1]
+		}
+		if current != nil {
+			if open || sustain {
+				lastActive = i
+				if energy > current.PeakDBFS {
+					current.PeakDBFS, current.PeakSeconds = energy, t
+				}
+			} else {
+				current.EndSeconds = float64(lastActive+1) * frameSeconds
+				current = nil
+			}
+		}
+	}
+	if current != nil {
+		current.EndSeconds = float64(lastActive+1) * frameSeconds
+	}
+
+	var merged []DetectedEvent
+	for _, e := range events {
+		if last := len(merged) - 1; last >= 0 && e.StartSeconds-merged[last].EndSeconds < a.cfg.MergeGapSeconds {
+			merged[last].EndSeconds = e.EndSeconds
+			if e.PeakDBFS > merged[last].PeakDBFS {
+				merged[last].PeakDBFS, merged[last].PeakSeconds = e.PeakDBFS, e.PeakSeconds
+			}
+			continue
+		}
+		merged = append(merged, e)
+	}
+	result := &EventDetection{ConfigKey: a.cfg.configKey(), Events: []DetectedEvent{}}
+	for _, e := range merged {
+		if e.EndSeconds-e.StartSeconds >= a.cfg.MinDurationSeconds {
+			result.Events = append(result.Events, e)
+		}
+	}
+	if len(result.Events) > eventMaxCount {
+		sort.Slice(result.Events, func(i, j int) bool { return result.Events[i].PeakDBFS > result.Events[j].PeakDBFS })
+		result.Events = result.Events[:eventMaxCount]
+		sort.Slice(result.Events, func(i, j int) bool { return result.Events[i].StartSeconds < result.Events[j].StartSeconds })
+	}
+	return result, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalAnalyzerProtocol 是发送给外部分析器的请求格式版本
+const externalAnalyzerProtocol = 1
+
+// ExternalAnalyzerSettings 描述一个外部分析器：一个本地可执行文件，从 stdin 读取
+// externalAnalyzerRequest，向 stdout 写出 ExternalAnalysisOutput，退出码非 0 视为失败
+type ExternalAnalyzerSettings struct {
+	Name           string   `json:"name"`            // 唯一名称，结果按名称保存
+	Command        []string `json:"command"`         // 可执行文件及参数
+	Version        string   `json:"version"`         // 模型或脚本的版本，变化后重新分析所有录音
+	TimeoutSeconds int      `json:"timeout_seconds"` // 单条录音的最长运行时间，默认 600 秒
+	Folders        []string `json:"folders"`         // 只分析这些文件夹及其子文件夹（相对 wav 目录），为空时分析全部
+}
+
+func (a ExternalAnalyzerSettings) configKey() string {
+	return fmt.Sprintf("%q %s", a.Command, a.Version)
+}
+
+func (a ExternalAnalyzerSettings) timeout() time.Duration {
+	if a.TimeoutSeconds > 0 {
+		return time.Duration(a.TimeoutSeconds) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+// appliesTo 判断分析器是否处理 sourceFilename 所在的文件夹
+func (a ExternalAnalyzerSettings) appliesTo(sourceFilename string) bool {
+	if len(a.Folders) == 0 {
+		return true
+	}
+	dir := path.Dir(filepath.ToSlash(sourceFilename))
+	for _, folder := range a.Folders {
+		folder = path.Clean(folder)
+		if folder == "." || dir == folder || strings.HasPrefix(dir, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// externalAnalyzerRequest 是写入外部分析器 stdin 的 JSON
+type externalAnalyzerRequest struct {
+	Protocol    int           `json:"protocol"`
+	SourcePath  string        `json:"source_path"`  // 源文件的绝对路径，分段录音为第一段
+	SourcePaths []string      `json:"source_paths"` // 所有分段的绝对路径，按顺序拼接为一条录音
+	Metadata    AudioMetadata `json:"metadata"`
+}
+
+// ExternalAnalysisOutput 是外部分析器写到 stdout 的 JSON，各项均可省略
+type ExternalAnalysisOutput struct {
+	Tags    []string       `json:"tags,omitempty"`
+	Markers []Marker       `json:"markers,omitempty"` // 时间相对源文件开头
+	Fields  map[string]any `json:"fields,omitempty"`  // 自定义字段，按原样显示
+}
+
+// ExternalAnalysis 是一个外部分析器对一条录音的结果，等待在管理界面中审核。
+// 只有接受的结果会发布到网站
+type ExternalAnalysis struct {
+	SourceHash string    `json:"source_hash"`
+	ConfigKey  string    `json:"config_key"`
+	AnalyzedAt time.Time `json:"analyzed_at"`
+	ExternalAnalysisOutput
+	Error  string `json:"error,omitempty"` // 运行失败时的错误，源文件或分析器变化后重试
+	Status string `json:"status,omitempty"`
+}
+
+// runExternalAnalyzers 对源文件或配置发生变化的录音运行外部分析器，结果按源文件哈希缓存
+func runExternalAnalyzers(metadata *AudioMetadata, settings Settings) {
+	if metadata.SourceHash == "" {
+		return
+	}
+	for _, analyzer := range settings.Analyzers {
+		if analyzer.Name == "" || len(analyzer.Command) == 0 || !analyzer.appliesTo(metadata.SourceFilename) {
+			continue
+		}
+		cached := metadata.External[analyzer.Name]
+		if cached != nil && cached.SourceHash == metadata.SourceHash && cached.ConfigKey == analyzer.configKey() {
+			continue
+		}
+		runExternalAnalyzer(metadata, analyzer)
+	}
+}
+
+// runExternalAnalyzer 运行一个外部分析器并保存结果，失败时记录错误
+func runExternalAnalyzer(metadata *AudioMetadata, analyzer ExternalAnalyzerSettings) {
+	log.Printf("Running analyzer %s on %s...", analyzer.Name, metadata.SourceFilename)
+	result := &ExternalAnalysis{
+		SourceHash: metadata.SourceHash,
+		ConfigKey:  analyzer.configKey(),
+		AnalyzedAt: time.Now(),
+	}
+	output, err := callExternalAnalyzer(analyzer, *metadata)
+	if err != nil {
+		log.Printf("Warning: Analyzer %s failed on %s: %v", analyzer.Name, metadata.SourceFilename, err)
+		result.Error = err.Error()
+	} else {
+		result.ExternalAnalysisOutput = output
+	}
+	if metadata.External == nil {
+		metadata.External = map[string]*ExternalAnalysis{}
+	}
+	metadata.External[analyzer.Name] = result
+}
+
+// externalReruns 记录在管理界面中触发、正在后台运行的分析器，键为 rerunKey
+var externalReruns sync.Map
+
+func rerunKey(sourceFilename, analyzer string) string {
+	return sourceFilename + "\x00" + analyzer
+}
+
+// rerunExternalAnalyzer 在后台重新运行一个分析器，完成后把结果写回 jsonFilePath。
+// 同一录音的同一分析器已在运行时不再启动，返回 false
+func rerunExternalAnalyzer(metadata AudioMetadata, jsonFilePath string, analyzer ExternalAnalyzerSettings) bool {
+	key := rerunKey(metadata.SourceFilename, analyzer.Name)
+	if _, running := externalReruns.LoadOrStore(key, true); running {
+		return false
+	}
+	go func() {
+		defer externalReruns.Delete(key)
+		runExternalAnalyzer(&metadata, analyzer)
+		// Reload so that edits saved while the analyzer was running are kept
+		current, err := loadAudioMetadata(jsonFilePath)
+		if err != nil {
+			log.Printf("Warning: Failed to save result of analyzer %s on %s: %v", analyzer.Name, metadata.SourceFilename, err)
+			return
+		}
+		if current.External == nil {
+			current.External = map[string]*ExternalAnalysis{}
+		}
+		current.External[analyzer.Name] = metadata.External[analyzer.Name]
+		content, err := json.MarshalIndent(current, "", "  ")
+		if err == nil {
+			err = os.WriteFile(jsonFilePath, content, 0644)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to save result of analyzer %s on %s: %v", analyzer.Name, metadata.SourceFilename, err)
+		}
+	}()
+	return true
+}
+
+// runningExternalAnalyzers 返回正在后台为该录音运行的分析器名称
+func runningExternalAnalyzers(sourceFilename string) map[string]bool {
+	running := map[string]bool{}
+	externalReruns.Range(func(key, _ any) bool {
+		if filename, name, _ := strings.Cut(key.(string), "\x00"); filename == sourceFilename {
+			running[name] = true
+		}
+		return true
+	})
+	return running
+}
+
+func callExternalAnalyzer(analyzer ExternalAnalyzerSettings, metadata AudioMetadata) (ExternalAnalysisOutput, error) {
+	var output ExternalAnalysisOutput
+	request := externalAnalyzerRequest{Protocol: externalAnalyzerProtocol, Metadata: metadata}
+	for _, p := range metadata.SourcePaths() {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return output, err
+		}
+		request.SourcePaths = append(request.SourcePaths, abs)
+	}
+	request.SourcePath = request.SourcePaths[0]
+	// Results of other analyzers are not part of the protocol
+	request.Metadata.External = nil
+	input, err := json.Marshal(request)
+	if err != nil {
+		return output, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), analyzer.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, analyzer.Command[0], analyzer.Command[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return output, fmt.Errorf("timed out after %v", analyzer.timeout())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return output, fmt.Errorf("%w, stderr: %s", err, msg)
+		}
+		return output, err
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return output, fmt.Errorf("invalid output: %w", err)
+	}
+
+	var markers []Marker
+	for i, m := range output.Markers {
+		if m.TimeSeconds < 0 || m.DurationSeconds < 0 {
+			continue
+		}
+		if m.Label = strings.TrimSpace(m.Label); m.Label == "" {
+			m.Label = fmt.Sprintf("%s %d", analyzer.Name, i+1)
+		}
+		markers = append(markers, m)
+	}
+	sortMarkers(markers)
+	output.Markers = markers
+	var tags []string
+	for _, tag := range output.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	output.Tags = tags
+	return output, nil
+}
+
+// PendingAnalyses 返回尚未审核的外部分析结果数，运行失败的不计入
+func (m AudioMetadata) PendingAnalyses() int {
+	n := 0
+	for _, result := range m.External {
+		if result != nil && result.Status == "" && result.Error == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// acceptedExternal 返回已接受的外部分析结果，按分析器名称排序
+func (m AudioMetadata) acceptedExternal() []*ExternalAnalysis {
+	names := make([]string, 0, len(m.External))
+	for name, result := range m.External {
+		if result != nil && result.Status == eventAccepted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	results := make([]*ExternalAnalysis, len(names))
+	for i, name := range names {
+		results[i] = m.External[name]
+	}
+	return results
+}
+
+// PublishedTags 返回已接受的外部分析结果中的标签，去除重复
+func (m AudioMetadata) PublishedTags() []string {
+	var tags []string
+	for _, result := range m.acceptedExternal() {
+		for _, tag := range result.Tags {
+			if !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// PublishedFields 返回已接受的外部分析结果中的自定义字段，同名字段以名称靠前的分析器为准
+func (m AudioMetadata) PublishedFields() map[string]any {
+	fields := map[string]any{}
+	for _, result := range m.acceptedExternal() {
+		for k, v := range result.Fields {
+			if _, ok := fields[k]; !ok {
+				fields[k] = v
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	featureVersion      = 2     // 特征定义变化时递增，旧结果会被重新计算
+	featureFrameSeconds = 0.046 // 每帧大约的时长，FFT 长度取不小于它的 2 的幂
+	featureMelBands     = 26
+	featureMFCCs        = 12   // 不含 c0，避免整体音量主导相似度
+	featureMinFreq      = 50.0 // Hz
+	featureMaxFreq      = 16000.0
+	featureSilenceDBFS  = -90.0 // 低于该电平的帧不计入特征
+	similarCount        = 6     // 每条录音保存的相似录音数
+)
+
+// featureOctaveBands 是频带能量特征使用的倍频程中心频率
+var featureOctaveBands = []float64{63, 125, 250, 500, 1000, 2000, 4000, 8000}
+
+// AudioFeatures 是用于查找相似录音的紧凑特征向量。Values 依次为：
+// MFCC c1-c12 的均值、c1-c12 的方差、频谱质心 (log2 Hz) 的均值和标准差、各倍频程能量占比 (dB)
+type AudioFeatures struct {
+	SourceHash string    `json:"source_hash"` // 计算特征所用的 m4a 缓存的哈希
+	Version    int       `json:"version"`
+	Values     []float64 `json:"values"`
+}
+
+// featureAnalyzer 逐帧计算 MFCC、频谱质心和倍频程能量，只保留累计量
+type featureAnalyzer struct {
+	sampleRate int
+	fftSize    int
+	window     []float64
+	frame      []float64
+	filled     int
+	buf        []complex128
+	power      []float64
+	melWeights [][]float64 // 每个 mel 频带对各频点的权重
+	octaveBins [][2]int    // 每个倍频程覆盖的频点范围
+	frames     int
+	mfccSum    []float64
+	mfccSumSq  []float64
+	centroid   float64
+	centroidSq float64
+	octaves    []float64
+}
+
+func newFeatureAnalyzer() *featureAnalyzer {
+	return &featureAnalyzer{
+		mfccSum:   make([]float64, featureMFCCs),
+		mfccSumSq: make([]float64, featureMFCCs),
+		octaves:   make([]float64, len(featureOctaveBands)),
+	}
+}
+
+func hzToMel(hz float64) float64  { return 2595 * math.Log10(1+hz/700) }
+func melToHz(mel float64) float64 { return 700 * (math.Pow(10, mel/2595) - 1) }
+
+func (a *featureAnalyzer) init(sampleRate int) {
+	a.sampleRate = sampleRate
+	a.fftSize = 256
+	for float64(a.fftSize) < featureFrameSeconds*float64(sampleRate) {
+		a.fftSize *= 2
+	}
+	a.window = make([]float64, a.fftSize)
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(a.fftSize-1))
+	}
+	a.frame = make([]float64, a.fftSize)
+	a.buf = make([]complex128, a.fftSize)
+	a.power = make([]float64, a.fftSize/2)
+	binHz := float64(sampleRate) / float64(a.fftSize)
+
+	// Triangular filters evenly spaced on the mel scale
+	maxFreq := math.Min(featureMaxFreq, float64(sampleRate)/2)
+	lowMel, highMel := hzToMel(featureMinFreq), hzToMel(maxFreq)
+	edges := make([]float64, featureMelBands+2)
+	for i := range edges {
+		edges[i] = melToHz(lowMel + (highMel-lowMel)*float64(i)/float64(featureMelBands+1))
+	}
+	a.melWeights = make([][]float64, featureMelBands)
+	for b := range a.melWeights {
+		weights := make([]float64, len(a.power))
+		for k := range weights {
+			f := float64(k) * binHz
+			switch {
+			case f > edges[b] && f <= edges[b+1]:
+				weights[k] = (f - edges[b]) / (edges[b+1] - edges[b])
+			case f > edges[b+1] && f < edges[b+2]:
+				weights[k] = (edges[b+2] - f) / (edges[b+2] - edges[b+1])
+			}
+		}
+		a.melWeights[b] = weights
+	}
+
+	a.octaveBins = make([][2]int, len(featureOctaveBands))
+	for i, center := range featureOctaveBands {
+		lo := int(math.Ceil(center / math.Sqrt2 / binHz))
+		hi := min(int(math.Floor(center*math.Sqrt2/binHz)), len(a.power)-1)
+		a.octaveBins[i] = [2]int{lo, hi}
+	}
+}
+
+func (a *featureAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if a.sampleRate == 0 {
+		a.init(sampleRate)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var mono float64
+		for c := 0; c < channels; c++ {
+			mono += samples[i+c]
+		}
+		a.frame[a.filled] = mono / float64(channels)
+		a.filled++
+		if a.filled == a.fftSize {
+			a.processFrame()
+			a.filled = 0
+		}
+	}
+}
+
+func (a *featureAnalyzer) processFrame() {
+	for i, x := range a.frame {
+		a.buf[i] = complex(x*a.window[i], 0)
+	}
+	fft(a.buf)
+	binHz := float64(a.sampleRate) / float64(a.fftSize)
+	var total, weighted float64
+	for k := range a.power {
+		m := cmplx.Abs(a.buf[k])
+		a.power[k] = m * m
+		if k > 0 {
+			total += a.power[k]
+			weighted += a.power[k] * float64(k) * binHz
+		}
+	}
+	// Normalize so the silence threshold is in dBFS: a full-scale sine sums to about (N/4)^2 * 1.5
+	norm := float64(a.fftSize) * float64(a.fftSize) / 16 * 1.5
+	if 10*math.Log10(total/norm+1e-30) < featureSilenceDBFS {
+		return
+	}
+	a.frames++
+
+	logMel := make([]float64, featureMelBands)
+	for b, weights := range a.melWeights {
+		var e float64
+		for k, w := range weights {
+			e += w * a.power[k]
+		}
+		logMel[b] = math.Log(e/norm + 1e-10)
+	}
+	for c := 1; c <= featureMFCCs; c++ {
+		var v float64
+		for b, e := range logMel {
+			v += e * math.Cos(math.Pi*float64(c)*(float64(b)+0.5)/featureMelBands)
+		}
+		v *= math.Sqrt(2.0 / featureMelBands)
+		a.mfccSum[c-1] += v
+		a.mfccSumSq[c-1] += v * v
+	}
+
+	centroid := math.Log2(math.Max(weighted/total, 1))
+	a.centroid += centroid
+	a.centroidSq += centroid * centroid
+	for i, bins := range a.octaveBins {
+		for k := bins[0]; k <= bins[1]; k++ {
+			a.octaves[i] += a.power[k] / total
+		}
+	}
+}
+
+func (a *featureAnalyzer) finish() (*AudioFeatures, error) {
+	if a.frames == 0 {
+		return nil, fmt.Errorf("no audible frames")
+	}
+	n := float64(a.frames)
+	values := make([]float64, 0, 2*featureMFCCs+2+len(featureOctaveBands))
+	for _, sum := range a.mfccSum {
+		values = append(values, sum/n)
+	}
+	for c, sumSq := range a.mfccSumSq {
+		mean := a.mfccSum[c] / n
+		values = append(values, math.Max(sumSq/n-mean*mean, 0))
+	}
+	mean := a.centroid / n
+	values = append(values, mean, math.Sqrt(math.Max(a.centroidSq/n-mean*mean, 0)))
+	for _, share := range a.octaves {
+		values = append(values, 10*math.Log10(share/n+1e-6))
+	}
+	return &AudioFeatures{Version: featureVersion, Values: values}, nil
+}
+
+// refreshFeatures 在生成时从发布用的 m4a 缓存计算录音的特征向量，使特征与发布的音频（已应用剪辑）一致。
+// 结果以缓存文件的哈希为键写回元数据文件，缓存不变时沿用保存的结果
+func refreshFeatures(meta *AudioMetadata, cachePath string) error {
+	hash, err := hashFile(cachePath)
+	if err != nil {
+		return err
+	}
+	if meta.Features != nil && meta.Features.SourceHash == hash && meta.Features.Version == featureVersion {
+		return nil
+	}
+	log.Printf("Computing audio features of %s...", meta.SourceFilename)
+	features := newFeatureAnalyzer()
+	if err := forEachAudioBlock(cachePath, 1, features.add); err != nil {
+		return err
+	}
+	result, err := features.finish()
+	if err != nil {
+		return err
+	}
+	result.SourceHash = hash
+	meta.Features = result
+
+	// Only the features are written back, meta already carries values that are resolved during generation
+	jsonPath := filepath.Join(jsonDir, sidecarBase(meta.SourceFilename)+".json")
+	stored, err := loadAudioMetadata(jsonPath)
+	if err != nil {
+		return err
+	}
+	stored.Features = result
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := os.WriteFile(jsonPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", jsonPath, err)
+	}
+	return nil
+}
+
+// SimilarRecording 是详情页“听起来相似”列表中的一项
+type SimilarRecording struct {
+	Title          string  `json:"title"`
+	Location       string  `json:"location,omitempty"`
+	DetailPagePath string  `json:"detail_page_path"` // 相对于 dist 目录
+	Distance       float64 `json:"distance"`         // 标准化特征空间中的欧氏距离，越小越相似
+}
+
+// findSimilarRecordings 为每条有特征的录音找出最相似的录音，以源文件名为键。
+// 各维特征先在整个资料库上标准化，使不同量纲的特征权重相当
+func findSimilarRecordings(metas []AudioMetadata) map[string][]SimilarRecording {
+	var vectors [][]float64
+	var owners []AudioMetadata
+	for _, meta := range metas {
+		if meta.Features != nil && meta.Features.Version == featureVersion {
+			vectors = append(vectors, append([]float64(nil), meta.Features.Values...))
+			owners = append(owners, meta)
+		}
+	}
+	result := map[string][]SimilarRecording{}
+	if len(vectors) < 2 {
+		return result
+	}
+	for d := range vectors[0] {
+		var sum, sumSq float64
+		for _, v := range vectors {
+			sum += v[d]
+			sumSq += v[d] * v[d]
+		}
+		mean := sum / float64(len(vectors))
+		std := math.Sqrt(math.Max(sumSq/float64(len(vectors))-mean*mean, 0))
+		for _, v := range vectors {
+			if std > 0 {
+				v[d] = (v[d] - mean) / std
+			} else {
+				v[d] = 0
+			}
+		}
+	}
+
+	for i, v := range vectors {
+		neighbours := make([]SimilarRecording, 0, len(vectors)-1)
+		for j, w := range vectors {
+			if i == j {
+				continue
+			}
+			var dist float64
+			for d := range v {
+				dist += (v[d] - w[d]) * (v[d] - w[d])
+			}
+			neighbours = append(neighbours, SimilarRecording{
+				Title:          owners[j].Title,
+				Location:       owners[j].Location,
+				DetailPagePath: owners[j].DetailPagePath(),
+				Distance:       math.Round(math.Sqrt(dist)*1000) / 1000,
+			})
+		}
+		sort.SliceStable(neighbours, func(a, b int) bool { return neighbours[a].Distance < neighbours[b].Distance })
+		if len(neighbours) > similarCount {
+			neighbours = neighbours[:similarCount]
+		}
+		result[owners[i].SourceFilename] = neighbours
+	}
+	return result
+}
+
+// writeSimilarExport 把相似录音列表写入 dist/data/similar.json，以详情页路径为键
+func writeSimilarExport(metas []AudioMetadata, similar map[string][]SimilarRecording) error {
+	dir := filepath.Join(distDir, "data")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	export := map[string][]SimilarRecording{}
+	for _, meta := range metas {
+		if neighbours, ok := similar[meta.SourceFilename]; ok {
+			export[meta.DetailPagePath()] = neighbours
+		}
+	}
+	jsonContent, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal similar recordings: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "similar.json"), jsonContent, 0644); err != nil {
+		return fmt.Errorf("failed to write similar.json: %w", err)
+	}
+	return nil
+}
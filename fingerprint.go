@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	fingerprintVersion      = 1
+	fingerprintBands        = 17 // 相邻频带的能量差给出 16 位
+	fingerprintMinFreq      = 250.0
+	fingerprintMaxFreq      = 4000.0 // 有损编码和低采样率副本也保留的频率范围
+	fingerprintStepSeconds  = 0.5    // 相邻两个词的间隔
+	fingerprintWindowSteps  = 4      // 每个窗口覆盖的步数，窗口远长于步长，裁剪了零点几秒的副本仍能对齐
+	fingerprintMaxPostings  = 200    // 出现次数过多的词不参与候选投票
+	fingerprintMaxBitErrors = 0.25   // 重叠部分的误码率不超过该值视为同一段录音，无关录音约为 0.5
+	fingerprintMinOverlap   = 0.8    // 重叠部分至少占较短录音的比例
+	fingerprintMinWords     = 20     // 至少重叠的词数
+)
+
+// AudioFingerprint 是对增益、重新编码和采样率变化不敏感的频谱指纹：每半秒一个 16 位的词，
+// 由当前 2 秒窗口与前一个不重叠窗口之间，各相邻频带能量差的变化方向组成
+type AudioFingerprint struct {
+	SourceHash string `json:"source_hash"`
+	Version    int    `json:"version"`
+	Words      string `json:"words"` // base64 编码的小端 uint16 序列
+}
+
+func (f *AudioFingerprint) words() []uint16 {
+	if f == nil || f.Version != fingerprintVersion {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(f.Words)
+	if err != nil {
+		return nil
+	}
+	words := make([]uint16, len(data)/2)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return words
+}
+
+// fingerprintAnalyzer 按步长累加各频带的能量，在 finish 中把相邻几步组成窗口计算指纹
+type fingerprintAnalyzer struct {
+	sampleRate int
+	fftSize    int
+	window     []float64
+	frame      []float64
+	filled     int
+	buf        []complex128
+	bandBins   [][2]int
+	frames     int
+	blocks     [][]float64 // 每一步各频带的能量
+}
+
+func newFingerprintAnalyzer() *fingerprintAnalyzer {
+	return &fingerprintAnalyzer{}
+}
+
+func (a *fingerprintAnalyzer) init(sampleRate int) {
+	a.sampleRate = sampleRate
+	a.fftSize = 256
+	for float64(a.fftSize) < 0.02*float64(sampleRate) {
+		a.fftSize *= 2
+	}
+	a.window = make([]float64, a.fftSize)
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(a.fftSize-1))
+	}
+	a.frame = make([]float64, a.fftSize)
+	a.buf = make([]complex128, a.fftSize)
+	binHz := float64(sampleRate) / float64(a.fftSize)
+	maxFreq := math.Min(fingerprintMaxFreq, float64(sampleRate)/2*0.9)
+	ratio := math.Pow(maxFreq/fingerprintMinFreq, 1/float64(fingerprintBands))
+	for b := 0; b < fingerprintBands; b++ {
+		lo := int(fingerprintMinFreq * math.Pow(ratio, float64(b)) / binHz)
+		hi := int(fingerprintMinFreq * math.Pow(ratio, float64(b+1)) / binHz)
+		a.bandBins = append(a.bandBins, [2]int{lo, max(hi, lo+1)})
+	}
+}
+
+func (a *fingerprintAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if a.sampleRate == 0 {
+		a.init(sampleRate)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var mono float64
+		for c := 0; c < channels; c++ {
+			mono += samples[i+c]
+		}
+		a.frame[a.filled] = mono / float64(channels)
+		a.filled++
+		if a.filled == a.fftSize {
+			a.processFrame()
+			a.filled = 0
+		}
+	}
+}
+
+func (a *fingerprintAnalyzer) processFrame() {
+	for i, x := range a.frame {
+		a.buf[i] = complex(x*a.window[i], 0)
+	}
+	fft(a.buf)
+	block := int(float64(a.frames*a.fftSize) / (float64(a.sampleRate) * fingerprintStepSeconds))
+	for len(a.blocks) <= block {
+		a.blocks = append(a.blocks, make([]float64, fingerprintBands))
+	}
+	for b, bins := range a.bandBins {
+		for k := bins[0]; k < bins[1]; k++ {
+			m := cmplx.Abs(a.buf[k])
+			a.blocks[block][b] += m * m
+		}
+	}
+	a.frames++
+}
+
+func (a *fingerprintAnalyzer) finish() (*AudioFingerprint, error) {
+	if len(a.blocks) < fingerprintWindowSteps+2 {
+		return nil, fmt.Errorf("recording is too short to fingerprint")
+	}
+	energy := make([][]float64, len(a.blocks)-fingerprintWindowSteps+1)
+	for n := range energy {
+		energy[n] = make([]float64, fingerprintBands)
+		for b := range energy[n] {
+			var sum float64
+			for _, block := range a.blocks[n : n+fingerprintWindowSteps] {
+				sum += block[b]
+			}
+			energy[n][b] = math.Log(sum + 1e-12)
+		}
+	}
+	data := make([]byte, 0, 2*(len(energy)-1))
+	for n := fingerprintWindowSteps; n < len(energy); n++ {
+		var word uint16
+		for b := 0; b < fingerprintBands-1; b++ {
+			diff := (energy[n][b] - energy[n][b+1]) - (energy[n-fingerprintWindowSteps][b] - energy[n-fingerprintWindowSteps][b+1])
+			if diff > 0 {
+				word |= 1 << b
+			}
+		}
+		data = binary.LittleEndian.AppendUint16(data, word)
+	}
+	return &AudioFingerprint{Version: fingerprintVersion, Words: base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// DuplicatePair 是内容相同（源文件哈希一致）或几乎相同（频谱指纹重叠）的两条录音
+type DuplicatePair struct {
+	A, B           AudioMetadata
+	Exact          bool
+	Similarity     float64 // 重叠部分指纹相同的比例
+	OffsetSeconds  float64 // B 的开头在 A 中的位置，负数表示 B 比 A 开始得早
+	OverlapSeconds float64
+}
+
+// findDuplicates 找出资料库中的重复录音：先按源文件哈希找完全相同的文件，
+// 再用指纹词的倒排索引为每对录音投票选出候选偏移，逐一核对误码率
+func findDuplicates(metas []AudioMetadata) []DuplicatePair {
+	var pairs []DuplicatePair
+	exact := map[[2]int]bool{}
+	byHash := map[string][]int{}
+	for i, meta := range metas {
+		if meta.SourceHash != "" {
+			byHash[meta.SourceHash] = append(byHash[meta.SourceHash], i)
+		}
+	}
+	for _, group := range byHash {
+		for x := 0; x < len(group); x++ {
+			for y := x + 1; y < len(group); y++ {
+				exact[[2]int{group[x], group[y]}] = true
+				pairs = append(pairs, DuplicatePair{A: metas[group[x]], B: metas[group[y]], Exact: true, Similarity: 1})
+			}
+		}
+	}
+
+	type posting struct{ recording, position int }
+	words := make([][]uint16, len(metas))
+	index := map[uint16][]posting{}
+	for i, meta := range metas {
+		words[i] = meta.Fingerprint.words()
+		for p, w := range words[i] {
+			// Silence and steady noise give all-zero words
+			if w != 0 {
+				index[w] = append(index[w], posting{i, p})
+			}
+		}
+	}
+
+	for i := range metas {
+		votes := map[[2]int]int{} // (recording, offset) -> votes
+		for p, w := range words[i] {
+			postings := index[w]
+			if w == 0 || len(postings) > fingerprintMaxPostings {
+				continue
+			}
+			for _, other := range postings {
+				if other.recording > i && !exact[[2]int{i, other.recording}] {
+					votes[[2]int{other.recording, p - other.position}]++
+				}
+			}
+		}
+		best := map[int]DuplicatePair{}
+		for key, count := range votes {
+			j, offset := key[0], key[1]
+			shorter := min(len(words[i]), len(words[j]))
+			if count < max(3, shorter/20) {
+				continue
+			}
+			similarity, overlap := compareFingerprints(words[i], words[j], offset)
+			if overlap < fingerprintMinWords || float64(overlap) < fingerprintMinOverlap*float64(shorter) || similarity < 1-fingerprintMaxBitErrors {
+				continue
+			}
+			if previous, ok := best[j]; !ok || similarity > previous.Similarity {
+				best[j] = DuplicatePair{
+					A:              metas[i],
+					B:              metas[j],
+					Similarity:     similarity,
+					OffsetSeconds:  float64(offset) * fingerprintStepSeconds,
+					OverlapSeconds: float64(overlap) * fingerprintStepSeconds,
+				}
+			}
+		}
+		for _, pair := range best {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	sort.Slice(pairs, func(x, y int) bool {
+		if pairs[x].Exact != pairs[y].Exact {
+			return pairs[x].Exact
+		}
+		if pairs[x].A.SourceFilename != pairs[y].A.SourceFilename {
+			return pairs[x].A.SourceFilename < pairs[y].A.SourceFilename
+		}
+		return pairs[x].B.SourceFilename < pairs[y].B.SourceFilename
+	})
+	return pairs
+}
+
+// loadDuplicatePairs 读取所有录音的元数据并查找重复录音
+func loadDuplicatePairs() ([]DuplicatePair, error) {
+	grouped, err := loadAllMetadataGroupedByFolder()
+	if err != nil {
+		return nil, err
+	}
+	var metas []AudioMetadata
+	for _, files := range grouped {
+		metas = append(metas, files...)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].SourceFilename < metas[j].SourceFilename })
+	return findDuplicates(metas), nil
+}
+
+// compareFingerprints 返回 b 从 a 的第 offset 个词开始对齐时，重叠部分相同位的比例和重叠的词数
+func compareFingerprints(a, b []uint16, offset int) (similarity float64, overlap int) {
+	start, end := max(offset, 0), min(len(a), offset+len(b))
+	if end <= start {
+		return 0, 0
+	}
+	errors := 0
+	for p := start; p < end; p++ {
+		x := a[p] ^ b[p-offset]
+		for ; x != 0; x &= x - 1 {
+			errors++
+		}
+	}
+	overlap = end - start
+	return 1 - float64(errors)/float64(overlap*(fingerprintBands-1)), overlap
+}
+
+// mergeMetadata 把 from 中由用户填写的内容补充到 into 中，into 已有的内容保持不变。
+// offsetSeconds 是 into 的开头在 from 中的位置，用于换算标记时间
+func mergeMetadata(into *AudioMetadata, from AudioMetadata, offsetSeconds float64) {
+	if defaultTitle := sidecarBase(filepath.Base(into.SourceFilename)); into.Title == "" || into.Title == defaultTitle {
+		if from.Title != sidecarBase(filepath.Base(from.SourceFilename)) {
+			into.Title = from.Title
+		}
+	}
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&into.Description, from.Description)
+	if into.Location == "" {
+		into.Location, into.PlaceID = from.Location, from.PlaceID
+	}
+	into.Privacy = into.Privacy.stricter(from.Privacy)
+	if into.Weather == nil {
+		into.Weather = from.Weather
+	}
+	fill(&into.Artist, from.Artist)
+	fill(&into.License, from.License)
+	fill(&into.Recorder, from.Recorder)
+	fill(&into.CoverImage, from.CoverImage)
+	if into.Latitude == nil && into.Longitude == nil {
+		into.Latitude, into.Longitude = from.Latitude, from.Longitude
+		refreshSkyContext(into)
+	}
+	for _, marker := range from.Markers {
+		marker.TimeSeconds -= offsetSeconds
+		if marker.TimeSeconds < 0 {
+			continue
+		}
+		duplicate := false
+		for _, existing := range into.Markers {
+			duplicate = duplicate || (existing.Label == marker.Label && math.Abs(existing.TimeSeconds-marker.TimeSeconds) < 1)
+		}
+		if !duplicate {
+			into.Markers = append(into.Markers, marker)
+		}
+	}
+	sortMarkers(into.Markers)
+}
+
+// formatDuplicateReport 把重复录音列表格式化为命令行报告
+func formatDuplicateReport(pairs []DuplicatePair) string {
+	if len(pairs) == 0 {
+		return "No duplicate recordings found.\n"
+	}
+	var b strings.Builder
+	for _, pair := range pairs {
+		a, c := filepath.ToSlash(pair.A.SourceFilename), filepath.ToSlash(pair.B.SourceFilename)
+		if pair.Exact {
+			fmt.Fprintf(&b, "identical  %s  %s\n", a, c)
+		} else {
+			fmt.Fprintf(&b, "similar    %s  %s  (%.0f%% match over %s, offset %+.1fs)\n", a, c, pair.Similarity*100, formatDuration(pair.OverlapSeconds), pair.OffsetSeconds)
+		}
+	}
+	fmt.Fprintf(&b, "%d duplicate pairs\n", len(pairs))
+	return b.String()
+}
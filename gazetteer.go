@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// GazetteerSettings 指向本地的 GeoNames 格式地名表（如 CN.txt、cities500.txt 或 allCountries.txt），
+// 用于根据坐标离线推荐结构化地址
+type GazetteerSettings struct {
+	Path          string  `json:"path"`            // 制表符分隔的 GeoNames 导出文件
+	Language      string  `json:"language"`        // "zh" 时优先使用别名中的中文名称
+	MaxDistanceKm float64 `json:"max_distance_km"` // 推荐地点的最远距离，默认 10 km
+	AutoFill      bool    `json:"auto_fill"`       // 扫描时为有坐标但没有结构化地址的录音自动填写
+}
+
+func (s GazetteerSettings) maxDistanceKm() float64 {
+	if s.MaxDistanceKm > 0 {
+		return s.MaxDistanceKm
+	}
+	return 10
+}
+
+// GeoAddress 是结构化的录音地址，各级均可为空
+type GeoAddress struct {
+	Site     string `json:"site,omitempty"`     // 具体地点，如海湾、山峰、村庄
+	District string `json:"district,omitempty"` // 市、区或县
+	Province string `json:"province,omitempty"`
+	Country  string `json:"country,omitempty"`
+}
+
+// String 从大到小排列各级地名，以 " · " 分隔
+func (a *GeoAddress) String() string {
+	if a == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range []string{a.Country, a.Province, a.District, a.Site} {
+		if p != "" && !containsString(parts, p) {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+func (a *GeoAddress) isEmpty() bool {
+	return a == nil || (a.Site == "" && a.District == "" && a.Province == "" && a.Country == "")
+}
+
+// gazetteerEntry 是地名表中的一个地点，只保留推荐地址所需的字段
+type gazetteerEntry struct {
+	name     string
+	lat, lon float64
+	country  string
+	admin1   string
+	admin2   string
+}
+
+// Gazetteer 是加载到内存的地名表，按 1° 网格索引
+type Gazetteer struct {
+	cells     map[[2]int][]gazetteerEntry
+	admin1    map[string]string // "CN.30" -> 名称
+	admin2    map[string]string // "CN.30.4401" -> 名称
+	countries map[string]string // "CN" -> 名称
+}
+
+var (
+	gazetteerMu     sync.Mutex
+	gazetteerCache  *Gazetteer
+	gazetteerLoaded GazetteerSettings
+)
+
+// loadGazetteer 读取设置中的地名表，结果缓存到设置变化为止。没有配置时返回 nil
+func loadGazetteer(settings GazetteerSettings) (*Gazetteer, error) {
+	if settings.Path == "" {
+		return nil, nil
+	}
+	gazetteerMu.Lock()
+	defer gazetteerMu.Unlock()
+	if gazetteerCache != nil && gazetteerLoaded == settings {
+		return gazetteerCache, nil
+	}
+	g, err := readGazetteer(settings.Path, settings.Language)
+	if err != nil {
+		return nil, err
+	}
+	gazetteerCache, gazetteerLoaded = g, settings
+	return g, nil
+}
+
+func gazetteerCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+func readGazetteer(path, language string) (*Gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gazetteer: %w", err)
+	}
+	defer f.Close()
+	log.Printf("Loading gazetteer %s...", path)
+	g := &Gazetteer{
+		cells:     map[[2]int][]gazetteerEntry{},
+		admin1:    map[string]string{},
+		admin2:    map[string]string{},
+		countries: map[string]string{},
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	count := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+		// country code, cc2, admin1, admin2, admin3, admin4, population, ...
+		fields := strings.Split(line, "\t")
+		if len(fields) < 15 || fields[6] == "" {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(fields[4], 64)
+		lon, err2 := strconv.ParseFloat(fields[5], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		name := fields[1]
+		if language == "zh" {
+			name = preferHanName(name, fields[3])
+		}
+		class, code, country := fields[6][0], fields[7], fields[8]
+		if class == 'A' {
+			switch code {
+			case "PCLI", "PCLD", "PCLS", "PCLF", "PCL", "TERR":
+				g.countries[country] = name
+			case "ADM1":
+				g.admin1[country+"."+fields[10]] = name
+			case "ADM2":
+				g.admin2[country+"."+fields[10]+"."+fields[11]] = name
+			}
+			continue
+		}
+		// Populated places, spots, terrain, water bodies and areas can be sites
+		if !strings.ContainsRune("PSTHL", rune(class)) {
+			continue
+		}
+		cell := gazetteerCell(lat, lon)
+		g.cells[cell] = append(g.cells[cell], gazetteerEntry{
+			name: name, lat: lat, lon: lon, country: country, admin1: fields[10], admin2: fields[11],
+		})
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read gazetteer: %w", err)
+	}
+	log.Printf("Loaded %d places from gazetteer", count)
+	return g, nil
+}
+
+// preferHanName 返回别名中第一个包含汉字的名称，没有时返回原名
+func preferHanName(name, alternates string) string {
+	for _, alt := range strings.Split(alternates, ",") {
+		for _, r := range alt {
+			if unicode.Is(unicode.Han, r) {
+				return alt
+			}
+		}
+	}
+	return name
+}
+
+// GeocodeCandidate 是坐标附近的一个候选地点
+type GeocodeCandidate struct {
+	Address    GeoAddress `json:"address"`
+	DistanceKm float64    `json:"distance_km"`
+}
+
+// GeocodeSuggestion 是根据坐标推荐的地址，Candidates 按距离排序，Address 取最近的一个
+type GeocodeSuggestion struct {
+	Address    GeoAddress         `json:"address"`
+	Candidates []GeocodeCandidate `json:"candidates"`
+}
+
+// reverseGeocode 查找 maxDistanceKm 内最近的若干地点
+func (g *Gazetteer) reverseGeocode(lat, lon, maxDistanceKm float64) *GeocodeSuggestion {
+	// One degree of latitude is about 111 km; degrees of longitude shrink towards the poles
+	latRadius := int(math.Ceil(maxDistanceKm / 111))
+	lonRadius := int(math.Ceil(maxDistanceKm / 111 / math.Max(math.Cos(lat*math.Pi/180), 0.01)))
+	center := gazetteerCell(lat, lon)
+	var candidates []GeocodeCandidate
+	for dLat := -latRadius; dLat <= latRadius; dLat++ {
+		for dLon := -lonRadius; dLon <= lonRadius; dLon++ {
+			for _, e := range g.cells[[2]int{center[0] + dLat, center[1] + dLon}] {
+				d := haversineMeters(lat, lon, e.lat, e.lon) / 1000
+				if d > maxDistanceKm {
+					continue
+				}
+				candidates = append(candidates, GeocodeCandidate{Address: g.address(e), DistanceKm: math.Round(d*100) / 100})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].DistanceKm < candidates[j].DistanceKm })
+	if len(candidates) > 8 {
+		candidates = candidates[:8]
+	}
+	return &GeocodeSuggestion{Address: candidates[0].Address, Candidates: candidates}
+}
+
+func (g *Gazetteer) address(e gazetteerEntry) GeoAddress {
+	country := g.countries[e.country]
+	if country == "" {
+		country = e.country
+	}
+	return GeoAddress{
+		Site:     e.name,
+		District: g.admin2[e.country+"."+e.admin1+"."+e.admin2],
+		Province: g.admin1[e.country+"."+e.admin1],
+		Country:  country,
+	}
+}
+
+// suggestAddress 用设置中的地名表推荐坐标处的地址，没有配置地名表或附近没有地点时返回 nil
+func suggestAddress(settings GazetteerSettings, lat, lon float64) (*GeocodeSuggestion, error) {
+	g, err := loadGazetteer(settings)
+	if err != nil || g == nil {
+		return nil, err
+	}
+	return g.reverseGeocode(lat, lon, settings.maxDistanceKm()), nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrackPoint 是 GPS 轨迹中带时间的一个位置
+type TrackPoint struct {
+	Time time.Time
+	Lat  float64
+	Lon  float64
+}
+
+// parseTrack 读取 GPX（trkpt/rtept/wpt）或 KML（gx:Track 以及带 TimeStamp 的 Point）轨迹，
+// 没有时间的点会被忽略，结果按时间排序
+func parseTrack(r io.Reader) ([]TrackPoint, error) {
+	decoder := xml.NewDecoder(r)
+	var points []TrackPoint
+	var text strings.Builder
+	// GPX point being read
+	var gpxPoint *TrackPoint
+	// KML gx:Track keeps <when> and <gx:coord> in two parallel lists
+	var whens []time.Time
+	var coords [][2]float64
+	// KML Placemark with a TimeStamp and a Point
+	var placemarkTime time.Time
+	var placemarkCoord *[2]float64
+
+	parseTime := func(s string) (time.Time, bool) {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+		return t, err == nil
+	}
+	flushKMLTrack := func() {
+		for i := 0; i < len(whens) && i < len(coords); i++ {
+			points = append(points, TrackPoint{Time: whens[i], Lat: coords[i][1], Lon: coords[i][0]})
+		}
+		whens, coords = nil, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse track: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			text.Reset()
+			switch t.Name.Local {
+			case "trkpt", "rtept", "wpt":
+				gpxPoint = &TrackPoint{}
+				for _, attr := range t.Attr {
+					v, _ := strconv.ParseFloat(attr.Value, 64)
+					switch attr.Name.Local {
+					case "lat":
+						gpxPoint.Lat = v
+					case "lon":
+						gpxPoint.Lon = v
+					}
+				}
+			case "Placemark":
+				placemarkTime, placemarkCoord = time.Time{}, nil
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			switch t.Name.Local {
+			case "time":
+				if ts, ok := parseTime(value); ok && gpxPoint != nil {
+					gpxPoint.Time = ts
+				}
+			case "trkpt", "rtept", "wpt":
+				if gpxPoint != nil && !gpxPoint.Time.IsZero() {
+					points = append(points, *gpxPoint)
+				}
+				gpxPoint = nil
+			case "when":
+				// Inside a TimeStamp this is the placemark time, inside gx:Track one of the samples
+				if ts, ok := parseTime(value); ok {
+					whens = append(whens, ts)
+					placemarkTime = ts
+				}
+			case "coord":
+				fields := strings.Fields(value)
+				if len(fields) >= 2 {
+					lon, err1 := strconv.ParseFloat(fields[0], 64)
+					lat, err2 := strconv.ParseFloat(fields[1], 64)
+					if err1 == nil && err2 == nil {
+						coords = append(coords, [2]float64{lon, lat})
+					}
+				}
+			case "coordinates":
+				// Only the first tuple of a Point is used
+				tuples := strings.Fields(value)
+				if len(tuples) == 0 {
+					break
+				}
+				fields := strings.Split(tuples[0], ",")
+				if len(fields) >= 2 {
+					lon, err1 := strconv.ParseFloat(fields[0], 64)
+					lat, err2 := strconv.ParseFloat(fields[1], 64)
+					if err1 == nil && err2 == nil {
+						placemarkCoord = &[2]float64{lon, lat}
+					}
+				}
+			case "Track":
+				flushKMLTrack()
+			case "Placemark":
+				if placemarkCoord != nil && !placemarkTime.IsZero() {
+					points = append(points, TrackPoint{Time: placemarkTime, Lat: placemarkCoord[1], Lon: placemarkCoord[0]})
+				}
+				whens, coords = nil, nil
+			}
+		}
+	}
+
+	valid := points[:0]
+	for _, p := range points {
+		if p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180 {
+			valid = append(valid, p)
+		}
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].Time.Before(valid[j].Time) })
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("no timestamped points found in track")
+	}
+	return valid, nil
+}
+
+// interpolateTrack 返回 t 时刻的位置：前后两个轨迹点都在 maxGap 之内时线性插值，
+// 只有一个在 maxGap 之内时取该点。gap 为到最近轨迹点的时间差
+func interpolateTrack(track []TrackPoint, t time.Time, maxGap time.Duration) (lat, lon float64, gap time.Duration, ok bool) {
+	i := sort.Search(len(track), func(i int) bool { return !track[i].Time.Before(t) })
+	var before, after *TrackPoint
+	if i > 0 && t.Sub(track[i-1].Time) <= maxGap {
+		before = &track[i-1]
+	}
+	if i < len(track) && track[i].Time.Sub(t) <= maxGap {
+		after = &track[i]
+	}
+	switch {
+	case before != nil && after != nil:
+		span := after.Time.Sub(before.Time)
+		if span <= 0 {
+			return after.Lat, after.Lon, 0, true
+		}
+		f := float64(t.Sub(before.Time)) / float64(span)
+		gap = min(t.Sub(before.Time), after.Time.Sub(t))
+		return before.Lat + (after.Lat-before.Lat)*f, before.Lon + (after.Lon-before.Lon)*f, gap, true
+	case before != nil:
+		return before.Lat, before.Lon, t.Sub(before.Time), true
+	case after != nil:
+		return after.Lat, after.Lon, after.Time.Sub(t), true
+	}
+	return 0, 0, 0, false
+}
+
+// GeotagMatch 是地理标记预览中的一行
+type GeotagMatch struct {
+	SourceFilename string
+	Title          string
+	RecordDate     time.Time
+	Latitude       *float64 // 当前坐标
+	Longitude      *float64
+	NewLatitude    float64
+	NewLongitude   float64
+	GapSeconds     float64 // 到最近轨迹点的时间差
+	Matched        bool
+}
+
+// DistanceMeters 返回新旧坐标之间的距离，没有旧坐标时为 -1
+func (m GeotagMatch) DistanceMeters() float64 {
+	if m.Latitude == nil || m.Longitude == nil {
+		return -1
+	}
+	return haversineMeters(*m.Latitude, *m.Longitude, m.NewLatitude, m.NewLongitude)
+}
+
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(math.Sqrt(a), 1))
+}
+
+// matchTrack 为 recordings 中的每条录音在轨迹上查找位置。offset 加到 RecordDate 上得到 GPS 时间，
+// 用于修正录音机时钟的偏差
+func matchTrack(recordings []AudioMetadata, track []TrackPoint, offset, maxGap time.Duration) []GeotagMatch {
+	matches := make([]GeotagMatch, 0, len(recordings))
+	for _, meta := range recordings {
+		m := GeotagMatch{
+			SourceFilename: meta.SourceFilename,
+			Title:          meta.Title,
+			RecordDate:     meta.RecordDate,
+			Latitude:       meta.Latitude,
+			Longitude:      meta.Longitude,
+		}
+		if lat, lon, gap, ok := interpolateTrack(track, meta.RecordDate.Add(offset), maxGap); ok {
+			m.NewLatitude = math.Round(lat*1e6) / 1e6
+			m.NewLongitude = math.Round(lon*1e6) / 1e6
+			m.GapSeconds = gap.Seconds()
+			m.Matched = true
+		}
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].RecordDate.Before(matches[j].RecordDate) })
+	return matches
+}
+
+// folderRecordings 返回文件夹中的录音，folder 与管理界面一致，根目录为 "/"
+func folderRecordings(folder string) ([]AudioMetadata, error) {
+	grouped, err := loadAllMetadataGroupedByFolder()
+	if err != nil {
+		return nil, err
+	}
+	recordings, ok := grouped[folder]
+	if !ok {
+		return nil, fmt.Errorf("folder %s not found or is empty", folder)
+	}
+	return recordings, nil
+}
+
+// applyGeotag 把坐标写入录音的元数据
+func applyGeotag(sourceFilename string, lat, lon float64) error {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return fmt.Errorf("coordinates out of range: %f, %f", lat, lon)
+	}
+	jsonPath := filepath.Join(jsonDir, sidecarBase(sourceFilename)+".json")
+	metadata, err := loadAudioMetadata(jsonPath)
+	if err != nil {
+		return err
+	}
+	metadata.Latitude, metadata.Longitude = &lat, &lon
+	refreshSkyContext(&metadata)
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal json for %s: %w", sourceFilename, err)
+	}
+	if err := os.WriteFile(jsonPath, updatedJsonContent, 0644); err != nil {
+		return fmt.Errorf("failed to write json file %s: %w", jsonPath, err)
+	}
+	return nil
+}
+
+// formatGeotagReport 把匹配结果格式化为命令行预览
+func formatGeotagReport(matches []GeotagMatch) string {
+	var b strings.Builder
+	matched := 0
+	for _, m := range matches {
+		name := filepath.ToSlash(m.SourceFilename)
+		if !m.Matched {
+			fmt.Fprintf(&b, "no fix     %s  %s\n", m.RecordDate.Format("2006-01-02 15:04:05"), name)
+			continue
+		}
+		matched++
+		change := "new"
+		if d := m.DistanceMeters(); d >= 0 {
+			change = fmt.Sprintf("moved %.0f m", d)
+		}
+		fmt.Fprintf(&b, "%10.6f %11.6f  %s  %s  (nearest fix %.0fs, %s)\n", m.NewLatitude, m.NewLongitude, m.RecordDate.Format("2006-01-02 15:04:05"), name, m.GapSeconds, change)
+	}
+	fmt.Fprintf(&b, "%d of %d recordings matched\n", matched, len(matches))
+	return b.String()
+}
+
+// runGeotagCommand 是 -geotag 命令行模式：打印预览，apply 为 true 时保存匹配到的坐标
+func runGeotagCommand(trackPath, folder string, offset, maxGap time.Duration, apply bool) error {
+	f, err := os.Open(trackPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	track, err := parseTrack(f)
+	if err != nil {
+		return err
+	}
+	recordings, err := folderRecordings(folder)
+	if err != nil {
+		return err
+	}
+	matches := matchTrack(recordings, track, offset, maxGap)
+	fmt.Print(formatGeotagReport(matches))
+	if !apply {
+		fmt.Println("Preview only, run again with -apply to save these positions.")
+		return nil
+	}
+	for _, m := range matches {
+		if m.Matched {
+			if err := applyGeotag(m.SourceFilename, m.NewLatitude, m.NewLongitude); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("Positions saved.")
+	return nil
+}
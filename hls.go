@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	hlsPlaylistFilename      = "playlist.m3u8"
+	defaultHLSSegmentSeconds = 10
+	defaultHLSMinDuration    = 600.0
+)
+
+func (h HLSSettings) segmentSeconds() int {
+	if h.SegmentSeconds > 0 {
+		return h.SegmentSeconds
+	}
+	return defaultHLSSegmentSeconds
+}
+
+func (h HLSSettings) minDuration() float64 {
+	if h.MinDurationSeconds > 0 {
+		return h.MinDurationSeconds
+	}
+	return defaultHLSMinDuration
+}
+
+// hlsArgs 返回把已编码的 AAC 重新封装为 fMP4 分段的 ffmpeg 参数，不会再次编码。
+// 分段不带任何标签，init.mp4 因此不会泄露隐私设置之外的位置
+func (h HLSSettings) hlsArgs(outputDir string) []string {
+	return []string{
+		"-map_metadata", "-1",
+		"-c:a", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(h.segmentSeconds()),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_segment_filename", filepath.Join(outputDir, "seg_%05d.m4s"),
+	}
+}
+
+// buildHLS 确保 hlsDir 中有对应 m4a 缓存的最新分段，并复制到 dist，返回相对于 dist 的播放列表路径。
+// 分段先写入临时目录，确认播放列表完整后再整体替换，中断时不会留下半成品。
+func buildHLS(manifest *CacheManifest, settings HLSSettings, cachePath, relPath string) (string, error) {
+	relDir := sidecarBase(relPath)
+	cacheDir := filepath.Join(hlsDir, relDir)
+	sourceHash, err := hashFile(cachePath)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := encoderFingerprint(settings.hlsArgs(""))
+	_, statErr := os.Stat(filepath.Join(cacheDir, hlsPlaylistFilename))
+	if statErr != nil || !manifest.isFresh(relDir, sourceHash, fingerprint) {
+		log.Printf("Segmenting %s for HLS...", relPath)
+		tmpDir := cacheDir + transcodeTempSuffix
+		if err := os.RemoveAll(tmpDir); err != nil {
+			return "", fmt.Errorf("failed to clean %s: %w", tmpDir, err)
+		}
+		defer os.RemoveAll(tmpDir) // No-op once the directory has been renamed into place
+		if err := os.MkdirAll(tmpDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", tmpDir, err)
+		}
+		args := append([]string{"-y", "-i", cachePath}, settings.hlsArgs(tmpDir)...)
+		args = append(args, filepath.Join(tmpDir, hlsPlaylistFilename))
+		if _, stderr, err := runCommand("ffmpeg", args...); err != nil {
+			return "", fmt.Errorf("ffmpeg HLS segmentation failed: %v, stderr: %s", err, stderr)
+		}
+		playlist, err := os.ReadFile(filepath.Join(tmpDir, hlsPlaylistFilename))
+		if err != nil {
+			return "", fmt.Errorf("failed to read generated playlist: %w", err)
+		}
+		if !strings.Contains(string(playlist), "#EXT-X-ENDLIST") {
+			return "", fmt.Errorf("generated playlist for %s is incomplete", relPath)
+		}
+		if err := os.RemoveAll(cacheDir); err != nil {
+			return "", fmt.Errorf("failed to remove old HLS output %s: %w", cacheDir, err)
+		}
+		if err := os.Rename(tmpDir, cacheDir); err != nil {
+			return "", fmt.Errorf("failed to move HLS output into place: %w", err)
+		}
+		manifest.record(relDir, sourceHash, fingerprint)
+		if err := manifest.save(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	dstDir := filepath.Join(distDir, "assets", "hls", relDir)
+	if err := copyDir(cacheDir, dstDir); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filepath.Join("assets", "hls", relDir, hlsPlaylistFilename)), nil
+}
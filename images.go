@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// publishedJPEGQuality 是去除元数据时重新编码 JPEG 使用的质量
+const publishedJPEGQuality = 90
+
+// writeStrippedImage 把 src 图片去掉 EXIF、XMP 等元数据后写到 dst。手机和相机照片的 EXIF
+// 带有拍摄坐标，发布前必须去掉。JPEG 和 PNG 解码后重新编码，并按 EXIF 方向旋转；
+// WebP 直接删除元数据块。其他格式返回错误
+func writeStrippedImage(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	switch strings.ToLower(filepath.Ext(src)) {
+	case ".jpg", ".jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", src, err)
+		}
+		if err := jpeg.Encode(&out, applyOrientation(img, jpegOrientation(data)), &jpeg.Options{Quality: publishedJPEGQuality}); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", dst, err)
+		}
+	case ".png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", src, err)
+		}
+		if err := png.Encode(&out, img); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", dst, err)
+		}
+	case ".webp":
+		stripped, err := stripWebPMetadata(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", src, err)
+		}
+		out.Write(stripped)
+	default:
+		return fmt.Errorf("unsupported image format: %s", src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, out.Bytes(), 0644)
+}
+
+// jpegOrientation 返回 JPEG 的 EXIF 方向 (1-8)，没有时返回 1
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break // Start of scan, no more metadata segments
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		segment := data[i+4 : min(i+2+size, len(data))]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation 从 EXIF 的 TIFF 结构中读取 IFD0 的 Orientation (0x0112)
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	for k := 0; k < int(order.Uint16(tiff[ifd:])); k++ {
+		entry := ifd + 2 + 12*k
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// applyOrientation 按 EXIF 方向把图像转正，去掉 EXIF 后浏览器不会再自行旋转
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// stripWebPMetadata 删除 WebP 中的 EXIF 和 XMP 块，并清除 VP8X 中对应的标志位
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file")
+	}
+	out := append([]byte(nil), data[:12]...)
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		if offset+8+size > len(data) {
+			return nil, fmt.Errorf("chunk %q runs past the end of the file", id)
+		}
+		// The padding byte of the last chunk is sometimes missing
+		end := min(offset+8+size+size%2, len(data))
+		switch id {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[offset:end]...)
+			if size > 0 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[offset:end]...)
+		}
+		offset = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// strippedTempImage 把图片去掉元数据后写入临时文件，返回其路径，由调用方删除
+func strippedTempImage(src string) (string, error) {
+	f, err := os.CreateTemp("", "cover-*"+strings.ToLower(filepath.Ext(src)))
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := writeStrippedImage(src, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const indexWindowSeconds = 60.0 // 分窗计算声学指数时每个窗口的长度
+
+// AcousticIndices 是一条录音的声学生态指数
+type AcousticIndices struct {
+	SourceHash string                `json:"source_hash"`       // 计算时源文件的哈希
+	ConfigKey  string                `json:"config_key"`        // 计算时使用的参数，参数变化后需重新计算
+	Values     AcousticIndexValues   `json:"values"`            // 整条录音的指数
+	Windows    []AcousticIndexValues `json:"windows,omitempty"` // 每分钟的指数，仅在 per_minute 开启时保存
+}
+
+// AcousticIndexValues 是一段音频的各项指数
+type AcousticIndexValues struct {
+	StartSeconds    float64 `json:"start_seconds"`
+	ACI             float64 `json:"aci"`              // Acoustic Complexity Index
+	ADI             float64 `json:"adi"`              // Acoustic Diversity Index
+	AEI             float64 `json:"aei"`              // Acoustic Evenness Index
+	BI              float64 `json:"bi"`               // Bioacoustic Index
+	NDSI            float64 `json:"ndsi"`             // Normalized Difference Soundscape Index
+	SpectralEntropy float64 `json:"spectral_entropy"` // 归一化的频谱熵 Hf
+}
+
+// FreqBand 是一个频带，单位 Hz
+type FreqBand struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (b FreqBand) orDefault(min, max float64) FreqBand {
+	if b.Max <= b.Min {
+		return FreqBand{Min: min, Max: max}
+	}
+	return b
+}
+
+// withDefaults 补全未设置的参数，默认值与 soundecology 一致
+func (s IndicesSettings) withDefaults() IndicesSettings {
+	if s.FFTSize <= 0 || s.FFTSize&(s.FFTSize-1) != 0 {
+		s.FFTSize = 512
+	}
+	if s.ADIMaxFreq <= 0 {
+		s.ADIMaxFreq = 10000
+	}
+	if s.ADIFreqStep <= 0 {
+		s.ADIFreqStep = 1000
+	}
+	if s.ADIThresholdDBFS == 0 {
+		s.ADIThresholdDBFS = -50
+	}
+	s.BIBand = s.BIBand.orDefault(2000, 8000)
+	s.NDSIAnthroBand = s.NDSIAnthroBand.orDefault(1000, 2000)
+	s.NDSIBioBand = s.NDSIBioBand.orDefault(2000, 11000)
+	return s
+}
+
+func (s IndicesSettings) configKey() string {
+	return fmt.Sprintf("%+v", s.withDefaults())
+}
+
+// indexAccumulator 累计一段音频的频谱统计量，可据此计算各项指数
+type indexAccumulator struct {
+	binHz    float64
+	frames   int
+	sumPower []float64 // 各频点功率之和
+	sumDB    []float64 // 各频点 dB 之和，用于 BI
+	aciDiff  []float64 // 各频点相邻帧强度差的绝对值之和
+	aciSum   []float64 // 各频点强度之和
+	prev     []float64
+	above    []int // 各频点高于 ADI 阈值的帧数
+}
+
+func newIndexAccumulator(bins int, binHz float64) *indexAccumulator {
+	return &indexAccumulator{
+		binHz:    binHz,
+		sumPower: make([]float64, bins),
+		sumDB:    make([]float64, bins),
+		aciDiff:  make([]float64, bins),
+		aciSum:   make([]float64, bins),
+		above:    make([]int, bins),
+	}
+}
+
+func (a *indexAccumulator) add(power []float64, thresholdPower float64) {
+	for k, p := range power {
+		amplitude := math.Sqrt(p)
+		a.sumPower[k] += p
+		a.sumDB[k] += 10 * math.Log10(p+1e-20)
+		a.aciSum[k] += amplitude
+		if a.prev != nil {
+			a.aciDiff[k] += math.Abs(amplitude - a.prev[k])
+		}
+		if p > thresholdPower {
+			a.above[k]++
+		}
+	}
+	if a.prev == nil {
+		a.prev = make([]float64, len(power))
+	}
+	for k := range power {
+		a.prev[k] = math.Sqrt(power[k])
+	}
+	a.frames++
+}
+
+func (a *indexAccumulator) binRange(band FreqBand) (int, int) {
+	lo := int(math.Ceil(band.Min / a.binHz))
+	hi := int(math.Floor(band.Max / a.binHz))
+	if hi >= len(a.sumPower) {
+		hi = len(a.sumPower) - 1
+	}
+	return lo, hi
+}
+
+func (a *indexAccumulator) values(cfg IndicesSettings) AcousticIndexValues {
+	var v AcousticIndexValues
+	if a.frames == 0 {
+		return v
+	}
+
+	// ACI: per-bin sum of absolute intensity differences over total intensity
+	for k := range a.aciSum {
+		if a.aciSum[k] > 0 {
+			v.ACI += a.aciDiff[k] / a.aciSum[k]
+		}
+	}
+
+	// ADI / AEI: share of cells above the threshold in each band, then Shannon entropy and Gini
+	var shares []float64
+	for f := 0.0; f < cfg.ADIMaxFreq; f += cfg.ADIFreqStep {
+		lo, hi := a.binRange(FreqBand{Min: f, Max: f + cfg.ADIFreqStep})
+		if hi < lo {
+			continue
+		}
+		count := 0
+		for k := lo; k <= hi; k++ {
+			count += a.above[k]
+		}
+		shares = append(shares, float64(count)/float64(a.frames*(hi-lo+1)))
+	}
+	v.ADI = shannonEntropy(shares)
+	v.AEI = giniCoefficient(shares)
+
+	// BI: area of the mean dB spectrum above its minimum inside the bio band, bin width in kHz
+	if lo, hi := a.binRange(cfg.BIBand); hi >= lo {
+		minDB := math.Inf(1)
+		for k := lo; k <= hi; k++ {
+			minDB = math.Min(minDB, a.sumDB[k]/float64(a.frames))
+		}
+		for k := lo; k <= hi; k++ {
+			v.BI += (a.sumDB[k]/float64(a.frames) - minDB) * a.binHz / 1000
+		}
+	}
+
+	// NDSI: (biophony - anthrophony) / (biophony + anthrophony)
+	bandPower := func(band FreqBand) float64 {
+		lo, hi := a.binRange(band)
+		var sum float64
+		for k := lo; k <= hi; k++ {
+			sum += a.sumPower[k]
+		}
+		return sum
+	}
+	bio, anthro := bandPower(cfg.NDSIBioBand), bandPower(cfg.NDSIAnthroBand)
+	if bio+anthro > 0 {
+		v.NDSI = (bio - anthro) / (bio + anthro)
+	}
+
+	// Hf: entropy of the mean spectrum, normalized to [0, 1]
+	if len(a.sumPower) > 1 {
+		v.SpectralEntropy = shannonEntropy(a.sumPower) / math.Log(float64(len(a.sumPower)))
+	}
+	return v
+}
+
+// shannonEntropy 把 values 归一化为概率分布后计算香农熵（自然对数）
+func shannonEntropy(values []float64) float64 {
+	var total float64
+	for _, x := range values {
+		total += x
+	}
+	if total <= 0 {
+		return 0
+	}
+	var h float64
+	for _, x := range values {
+		if x > 0 {
+			p := x / total
+			h -= p * math.Log(p)
+		}
+	}
+	return h
+}
+
+func giniCoefficient(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	var total, weighted float64
+	for i, x := range sorted {
+		total += x
+		weighted += float64(i+1) * x
+	}
+	n := float64(len(sorted))
+	if total <= 0 || n == 0 {
+		return 0
+	}
+	return 2*weighted/(n*total) - (n+1)/n
+}
+
+// indicesAnalyzer 把音频混为单声道，按 FFT 帧计算功率谱，同时累计整条录音和每分钟窗口的统计量
+type indicesAnalyzer struct {
+	cfg        IndicesSettings
+	window     []float64
+	windowGain float64
+	frame      []float64
+	filled     int
+	sampleRate int
+	whole      *indexAccumulator
+	current    *indexAccumulator
+	windows    []AcousticIndexValues
+	frameCount int
+	threshold  float64
+	buf        []complex128
+	power      []float64
+}
+
+func newIndicesAnalyzer(cfg IndicesSettings) *indicesAnalyzer {
+	cfg = cfg.withDefaults()
+	a := &indicesAnalyzer{
+		cfg:       cfg,
+		window:    make([]float64, cfg.FFTSize),
+		frame:     make([]float64, cfg.FFTSize),
+		buf:       make([]complex128, cfg.FFTSize),
+		power:     make([]float64, cfg.FFTSize/2),
+		threshold: math.Pow(10, cfg.ADIThresholdDBFS/10),
+	}
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(cfg.FFTSize-1))
+		a.windowGain += a.window[i]
+	}
+	return a
+}
+
+func (a *indicesAnalyzer) framesPerWindow() int {
+	return int(indexWindowSeconds * float64(a.sampleRate) / float64(a.cfg.FFTSize))
+}
+
+func (a *indicesAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if a.whole == nil {
+		a.sampleRate = sampleRate
+		binHz := float64(sampleRate) / float64(a.cfg.FFTSize)
+		a.whole = newIndexAccumulator(len(a.power), binHz)
+		a.current = newIndexAccumulator(len(a.power), binHz)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var mono float64
+		for c := 0; c < channels; c++ {
+			mono += samples[i+c]
+		}
+		a.frame[a.filled] = mono / float64(channels)
+		a.filled++
+		if a.filled == len(a.frame) {
+			a.processFrame()
+			a.filled = 0
+		}
+	}
+}
+
+func (a *indicesAnalyzer) processFrame() {
+	for i, x := range a.frame {
+		a.buf[i] = complex(x*a.window[i], 0)
+	}
+	fft(a.buf)
+	// Scale so a full-scale sine reads about 0 dBFS
+	scale := 2 / a.windowGain
+	for k := range a.power {
+		m := cmplx.Abs(a.buf[k]) * scale
+		a.power[k] = m * m
+	}
+	a.whole.add(a.power, a.threshold)
+	a.current.add(a.power, a.threshold)
+	a.frameCount++
+	if a.current.frames >= a.framesPerWindow() {
+		a.flushWindow()
+	}
+}
+
+func (a *indicesAnalyzer) flushWindow() {
+	if a.current.frames == 0 {
+		return
+	}
+	values := a.current.values(a.cfg)
+	values.StartSeconds = float64(a.frameCount-a.current.frames) * float64(a.cfg.FFTSize) / float64(a.sampleRate)
+	a.windows = append(a.windows, values)
+	a.current = newIndexAccumulator(len(a.power), a.whole.binHz)
+}
+
+func (a *indicesAnalyzer) finish() (*AcousticIndices, error) {
+	if a.whole == nil || a.whole.frames == 0 {
+		return nil, fmt.Errorf("recording is shorter than one FFT frame")
+	}
+	// Keep a trailing partial window only if it covers at least a quarter of a minute
+	if a.current.frames*4 >= a.framesPerWindow() || len(a.windows) == 0 {
+		a.flushWindow()
+	}
+	result := &AcousticIndices{
+		ConfigKey: a.cfg.configKey(),
+		Values:    a.whole.values(a.cfg),
+	}
+	// ACI grows with duration, so report the per-minute mean to keep recordings comparable
+	var aci float64
+	for _, w := range a.windows {
+		aci += w.ACI
+	}
+	result.Values.ACI = aci / float64(len(a.windows))
+	if a.cfg.PerMinute {
+		result.Windows = a.windows
+	}
+	return result, nil
+}
+
+// fft 原地计算基 2 快速傅里叶变换，len(x) 必须是 2 的幂
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// indexName 是一项指数在页面和导出文件中的键和显示名称
+type indexName struct {
+	Key   string
+	Label string
+}
+
+var acousticIndexNames = []indexName{
+	{Key: "aci", Label: "ACI 声学复杂度"},
+	{Key: "adi", Label: "ADI 声学多样性"},
+	{Key: "aei", Label: "AEI 声学均匀度"},
+	{Key: "bi", Label: "BI 生物声学指数"},
+	{Key: "ndsi", Label: "NDSI 归一化声景差异"},
+	{Key: "spectral_entropy", Label: "Hf 频谱熵"},
+}
+
+func indexValue(v AcousticIndexValues, key string) float64 {
+	switch key {
+	case "aci":
+		return v.ACI
+	case "adi":
+		return v.ADI
+	case "aei":
+		return v.AEI
+	case "bi":
+		return v.BI
+	case "ndsi":
+		return v.NDSI
+	case "spectral_entropy":
+		return v.SpectralEntropy
+	}
+	return 0
+}
+
+func indexSeries(windows []AcousticIndexValues, key string) []float64 {
+	series := make([]float64, len(windows))
+	for i, w := range windows {
+		series[i] = indexValue(w, key)
+	}
+	return series
+}
+
+// indicesExport 是 indices.json 中单条录音的数据
+type indicesExport struct {
+	DetailPagePath string                `json:"detail_page_path"` // 相对于 dist 目录，作为录音的公开标识
+	Title          string                `json:"title"`
+	Location       string                `json:"location"`
+	RecordDate     time.Time             `json:"record_date"`
+	Values         AcousticIndexValues   `json:"values"`
+	Windows        []AcousticIndexValues `json:"windows,omitempty"`
+}
+
+// writeIndicesExports 把所有录音的声学指数导出为 dist/data 下的 JSON 和 CSV；
+// indices.csv 每条录音一行，indices_minutes.csv 每分钟窗口一行。metas 应是 publicMetadata 处理后的元数据
+func writeIndicesExports(metas []AudioMetadata) error {
+	dir := filepath.Join(distDir, "data")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	header := []string{"detail_page_path", "title", "location", "record_date", "start_seconds"}
+	for _, n := range acousticIndexNames {
+		header = append(header, n.Key)
+	}
+	row := func(meta AudioMetadata, v AcousticIndexValues) []string {
+		r := []string{meta.DetailPagePath(), meta.Title, meta.Location, meta.RecordDate.Format(time.RFC3339), strconv.FormatFloat(v.StartSeconds, 'f', 1, 64)}
+		for _, n := range acousticIndexNames {
+			r = append(r, strconv.FormatFloat(indexValue(v, n.Key), 'f', 6, 64))
+		}
+		return r
+	}
+
+	var exports []indicesExport
+	recordings := [][]string{header}
+	minutes := [][]string{header}
+	for _, meta := range metas {
+		if meta.Indices == nil {
+			continue
+		}
+		exports = append(exports, indicesExport{
+			DetailPagePath: meta.DetailPagePath(),
+			Title:          meta.Title,
+			Location:       meta.Location,
+			RecordDate:     meta.RecordDate,
+			Values:         meta.Indices.Values,
+			Windows:        meta.Indices.Windows,
+		})
+		recordings = append(recordings, row(meta, meta.Indices.Values))
+		for _, w := range meta.Indices.Windows {
+			minutes = append(minutes, row(meta, w))
+		}
+	}
+
+	jsonContent, err := json.MarshalIndent(exports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal indices export: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "indices.json"), jsonContent, 0644); err != nil {
+		return fmt.Errorf("failed to write indices.json: %w", err)
+	}
+	if err := writeCSV(filepath.Join(dir, "indices.csv"), recordings); err != nil {
+		return err
+	}
+	if len(minutes) > 1 {
+		if err := writeCSV(filepath.Join(dir, "indices_minutes.csv"), minutes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
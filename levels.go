@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+)
+
+const (
+	levelWindowSeconds = 0.125  // Fast 时间计权的近似：按 125 ms 窗口计算等效声级
+	levelHistogramStep = 0.1    // 统计声级的直方图精度 (dB)
+	levelHistogramMin  = -160.0 // 直方图下限 (dBFS)
+	levelHistogramMax  = 10.0
+)
+
+// LevelStats 是 A/C 计权的声级统计。A、C 中的数值以 dBFS 为单位（满幅正弦波为 0），
+// 加上 CalibrationOffsetDB 即为 dB SPL
+type LevelStats struct {
+	SourceHash          string       `json:"source_hash"`
+	A                   LevelMetrics `json:"a"`
+	C                   LevelMetrics `json:"c"`
+	CalibrationOffsetDB float64      `json:"calibration_offset_db"`
+	Calibrated          bool         `json:"calibrated"` // 录音机在 settings.json 中有校准值
+}
+
+// LevelMetrics 是一种计权下的等效声级和统计声级
+type LevelMetrics struct {
+	Leq  float64 `json:"leq"`
+	L10  float64 `json:"l10"`
+	L50  float64 `json:"l50"`
+	L90  float64 `json:"l90"`
+	Lmax float64 `json:"lmax"`
+}
+
+func (m LevelMetrics) shift(offset float64) LevelMetrics {
+	return LevelMetrics{Leq: m.Leq + offset, L10: m.L10 + offset, L50: m.L50 + offset, L90: m.L90 + offset, Lmax: m.Lmax + offset}
+}
+
+// SPLA 返回校准后的 A 计权声级；未校准时返回 dBFS 数值
+func (l *LevelStats) SPLA() LevelMetrics { return l.A.shift(l.CalibrationOffsetDB) }
+
+// SPLC 返回校准后的 C 计权声级；未校准时返回 dBFS 数值
+func (l *LevelStats) SPLC() LevelMetrics { return l.C.shift(l.CalibrationOffsetDB) }
+
+// Unit 返回声级的单位
+func (l *LevelStats) Unit() string {
+	if l.Calibrated {
+		return "dB SPL"
+	}
+	return "dBFS"
+}
+
+// calibrationOffset 查找录音机的校准值（dB SPL = dBFS + 校准值），名称不区分大小写，
+// 找不到时使用 "default" 项
+func (s Settings) calibrationOffset(recorder string) (float64, bool) {
+	for name, offset := range s.Calibration {
+		if recorder != "" && strings.EqualFold(name, recorder) {
+			return offset, true
+		}
+	}
+	offset, ok := s.Calibration["default"]
+	return offset, ok
+}
+
+// applyCalibration 按当前设置更新校准值，校准值只影响显示，不需要重新分析
+func (l *LevelStats) applyCalibration(settings Settings, recorder string) {
+	l.CalibrationOffsetDB, l.Calibrated = settings.calibrationOffset(recorder)
+}
+
+// weightingFilter 是由一阶节级联而成的 IIR 频率计权滤波器
+type weightingFilter struct {
+	zeros, poles []float64
+	gain         float64
+	x1, y1       []float64 // 各节的上一个输入和输出
+}
+
+// newWeightingFilter 用预畸变双线性变换把模拟原型（零点在 s=0，实数极点）转为数字滤波器，
+// 并归一化为 1 kHz 处 0 dB
+func newWeightingFilter(sampleRate float64, zerosAtOrigin int, poleFreqs []float64) *weightingFilter {
+	f := &weightingFilter{gain: 1}
+	for i := 0; i < zerosAtOrigin; i++ {
+		f.zeros = append(f.zeros, 1)
+	}
+	// The bilinear transform sends the excess poles' zeros at infinity to Nyquist
+	for i := zerosAtOrigin; i < len(poleFreqs); i++ {
+		f.zeros = append(f.zeros, -1)
+	}
+	for _, freq := range poleFreqs {
+		w := 2 * sampleRate * math.Tan(math.Pi*freq/sampleRate) // Prewarped angular frequency
+		k := w / (2 * sampleRate)
+		f.poles = append(f.poles, (1-k)/(1+k))
+	}
+	z := cmplx.Exp(complex(0, 2*math.Pi*1000/sampleRate))
+	response := complex(1, 0)
+	for i := range f.poles {
+		response *= (1 - complex(f.zeros[i], 0)/z) / (1 - complex(f.poles[i], 0)/z)
+	}
+	f.gain = 1 / cmplx.Abs(response)
+	f.x1 = make([]float64, len(f.poles))
+	f.y1 = make([]float64, len(f.poles))
+	return f
+}
+
+func newAWeighting(sampleRate float64) *weightingFilter {
+	return newWeightingFilter(sampleRate, 4, []float64{20.598997, 20.598997, 107.65265, 737.86223, 12194.217, 12194.217})
+}
+
+func newCWeighting(sampleRate float64) *weightingFilter {
+	return newWeightingFilter(sampleRate, 2, []float64{20.598997, 20.598997, 12194.217, 12194.217})
+}
+
+func (f *weightingFilter) process(x float64) float64 {
+	for i := range f.poles {
+		y := x - f.zeros[i]*f.x1[i] + f.poles[i]*f.y1[i]
+		f.x1[i], f.y1[i] = x, y
+		x = y
+	}
+	return x * f.gain
+}
+
+// levelHistogram 记录各窗口声级的分布以计算统计声级，并累计总能量计算 Leq
+type levelHistogram struct {
+	counts     []int
+	windows    int
+	energy     float64
+	max        float64
+	sumSquares float64
+	samples    int
+}
+
+func newLevelHistogram() *levelHistogram {
+	return &levelHistogram{
+		counts: make([]int, int((levelHistogramMax-levelHistogramMin)/levelHistogramStep)+1),
+		max:    math.Inf(-1),
+	}
+}
+
+func (h *levelHistogram) addSample(power float64) {
+	h.sumSquares += power
+	h.samples++
+}
+
+// closeWindow 结束当前窗口并记录其等效声级
+func (h *levelHistogram) closeWindow() {
+	if h.samples == 0 {
+		return
+	}
+	meanSquare := h.sumSquares / float64(h.samples)
+	h.energy += meanSquare
+	level := powerToDBFS(meanSquare)
+	h.max = math.Max(h.max, level)
+	bin := int(math.Round((math.Max(math.Min(level, levelHistogramMax), levelHistogramMin) - levelHistogramMin) / levelHistogramStep))
+	h.counts[bin]++
+	h.windows++
+	h.sumSquares, h.samples = 0, 0
+}
+
+// percentile 返回超过 n% 时间的声级 Ln
+func (h *levelHistogram) percentile(n float64) float64 {
+	target := float64(h.windows) * n / 100
+	var seen float64
+	for bin := len(h.counts) - 1; bin >= 0; bin-- {
+		seen += float64(h.counts[bin])
+		if seen >= target {
+			return levelHistogramMin + float64(bin)*levelHistogramStep
+		}
+	}
+	return levelHistogramMin
+}
+
+func (h *levelHistogram) metrics() LevelMetrics {
+	return LevelMetrics{
+		Leq:  powerToDBFS(h.energy / float64(h.windows)),
+		L10:  h.percentile(10),
+		L50:  h.percentile(50),
+		L90:  h.percentile(90),
+		Lmax: h.max,
+	}
+}
+
+// powerToDBFS 把均方值转为 dBFS，满幅正弦波（均方值 0.5）为 0 dBFS
+func powerToDBFS(meanSquare float64) float64 {
+	return math.Max(10*math.Log10(meanSquare*2+1e-30), levelHistogramMin)
+}
+
+// levelAnalyzer 对每个通道分别做 A/C 计权，按窗口统计各通道平均功率
+type levelAnalyzer struct {
+	aFilters, cFilters []*weightingFilter
+	a, c               *levelHistogram
+	windowFrames       int
+	frameInWindow      int
+}
+
+func newLevelAnalyzer() *levelAnalyzer {
+	return &levelAnalyzer{a: newLevelHistogram(), c: newLevelHistogram()}
+}
+
+func (l *levelAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if l.aFilters == nil {
+		for c := 0; c < channels; c++ {
+			l.aFilters = append(l.aFilters, newAWeighting(float64(sampleRate)))
+			l.cFilters = append(l.cFilters, newCWeighting(float64(sampleRate)))
+		}
+		l.windowFrames = int(float64(sampleRate) * levelWindowSeconds)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var aPower, cPower float64
+		for c := 0; c < channels; c++ {
+			a := l.aFilters[c].process(samples[i+c])
+			cw := l.cFilters[c].process(samples[i+c])
+			aPower += a * a
+			cPower += cw * cw
+		}
+		l.a.addSample(aPower / float64(channels))
+		l.c.addSample(cPower / float64(channels))
+		l.frameInWindow++
+		if l.frameInWindow == l.windowFrames {
+			l.a.closeWindow()
+			l.c.closeWindow()
+			l.frameInWindow = 0
+		}
+	}
+}
+
+func (l *levelAnalyzer) finish() (*LevelStats, error) {
+	l.a.closeWindow()
+	l.c.closeWindow()
+	if l.a.windows == 0 {
+		return nil, fmt.Errorf("no audio samples decoded")
+	}
+	return &LevelStats{A: l.a.metrics(), C: l.c.metrics()}, nil
+}
+
+// LocationLevels 是同一地点（且单位相同）的录音的声级汇总
+type LocationLevels struct {
+	Location     string
+	Unit         string
+	Count        int
+	TotalSeconds float64
+	A, C         LevelMetrics
+}
+
+// aggregateLevelsByLocation 按地点汇总声级：Leq 按时长做能量平均，L10/L50/L90 按时长加权平均，
+// Lmax 取最大值。已校准和未校准的录音单位不同，分开汇总
+func aggregateLevelsByLocation(metas []AudioMetadata) []LocationLevels {
+	type accumulator struct {
+		LocationLevels
+		aEnergy, cEnergy float64
+	}
+	var order []string
+	groups := map[string]*accumulator{}
+	for _, meta := range metas {
+		if meta.Levels == nil {
+			continue
+		}
+		location := strings.TrimSpace(meta.Location)
+		if location == "" {
+			location = "未标注地点"
+		}
+		unit := meta.Levels.Unit()
+		key := location + "\x00" + unit
+		g, ok := groups[key]
+		if !ok {
+			g = &accumulator{LocationLevels: LocationLevels{Location: location, Unit: unit}}
+			g.A.Lmax, g.C.Lmax = math.Inf(-1), math.Inf(-1)
+			groups[key] = g
+			order = append(order, key)
+		}
+		weight := math.Max(meta.DurationSeconds, 1)
+		a, c := meta.Levels.SPLA(), meta.Levels.SPLC()
+		g.Count++
+		g.TotalSeconds += weight
+		g.aEnergy += weight * math.Pow(10, a.Leq/10)
+		g.cEnergy += weight * math.Pow(10, c.Leq/10)
+		g.A.L10, g.A.L50, g.A.L90 = g.A.L10+weight*a.L10, g.A.L50+weight*a.L50, g.A.L90+weight*a.L90
+		g.C.L10, g.C.L50, g.C.L90 = g.C.L10+weight*c.L10, g.C.L50+weight*c.L50, g.C.L90+weight*c.L90
+		g.A.Lmax, g.C.Lmax = math.Max(g.A.Lmax, a.Lmax), math.Max(g.C.Lmax, c.Lmax)
+	}
+	result := make([]LocationLevels, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		for _, m := range []*LevelMetrics{&g.A, &g.C} {
+			m.L10, m.L50, m.L90 = m.L10/g.TotalSeconds, m.L50/g.TotalSeconds, m.L90/g.TotalSeconds
+		}
+		g.A.Leq = 10 * math.Log10(g.aEnergy/g.TotalSeconds)
+		g.C.Leq = 10 * math.Log10(g.cEnergy/g.TotalSeconds)
+		result = append(result, g.LocationLevels)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Location != result[j].Location {
+			return result[i].Location < result[j].Location
+		}
+		return result[i].Unit > result[j].Unit // dB SPL first
+	})
+	return result
+}
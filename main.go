@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,12 +26,19 @@ var iconFS embed.FS
 
 func main() {
 	// --- 命令行参数处理 ---
-	wavPathFlag := flag.String("wav", "", "Path to the directory containing WAV files (required)")
+	wavPathFlag := flag.String("wav", "", "Path to the directory containing source audio files: WAV, FLAC, AIFF, MP3, M4A... (required)")
 	genFlag := flag.Bool("gen", false, "Generate static site directly without starting the server")
+	duplicatesFlag := flag.Bool("duplicates", false, "Print a report of duplicate and near-duplicate recordings and exit")
+	geotagFlag := flag.String("geotag", "", "GPX or KML track to geotag the recordings of -folder with; prints a preview and exits")
+	folderFlag := flag.String("folder", "/", "Folder relative to -wav used by -geotag and -weather, \"/\" for the root folder")
+	offsetFlag := flag.Duration("offset", 0, "Added to each recording's time before looking it up in the -geotag track, to correct the recorder clock")
+	maxGapFlag := flag.Duration("max-gap", 10*time.Minute, "Maximum time between a recording and the nearest -geotag track point")
+	weatherFlag := flag.String("weather", "", "Weather station CSV log to import for the recordings of -folder; prints a preview and exits")
+	applyFlag := flag.Bool("apply", false, "Save the -geotag positions or -weather conditions instead of only printing them")
 	flag.Parse()
 
 	if *wavPathFlag == "" {
-		fmt.Println("WAV directory path is required. Use the -wav flag.")
+		fmt.Println("Source audio directory path is required. Use the -wav flag.")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -36,21 +46,30 @@ func main() {
 	wavDir = *wavPathFlag
 	info, err := os.Stat(wavDir)
 	if err != nil || !info.IsDir() {
-		log.Fatalf("Invalid WAV directory path provided: %s", wavDir)
+		log.Fatalf("Invalid source audio directory path provided: %s", wavDir)
 	}
 
 	jsonDir = filepath.Join(filepath.Dir(wavDir), "json")
 	m4aDir = filepath.Join(filepath.Dir(wavDir), "m4a")
+	previewDir = filepath.Join(filepath.Dir(wavDir), "preview")
+	hlsDir = filepath.Join(filepath.Dir(wavDir), "hls")
+	spectrogramDir = filepath.Join(filepath.Dir(wavDir), "spectrogram")
+	taggedDir = filepath.Join(filepath.Dir(wavDir), "tagged")
+	placePhotoDir = filepath.Join(filepath.Dir(wavDir), "places")
 
-	fmt.Printf("Source WAV directory: %s\n", wavDir)
+	fmt.Printf("Source audio directory: %s\n", wavDir)
 	fmt.Printf("Metadata JSON directory: %s\n", jsonDir)
 	fmt.Printf("M4A Cache directory: %s\n", m4aDir)
-
-	if err := os.MkdirAll(jsonDir, 0755); err != nil {
-		log.Fatalf("Failed to create %s directory: %v", jsonDir, err)
-	}
-	if err := os.MkdirAll(m4aDir, 0755); err != nil {
-		log.Fatalf("Failed to create %s directory: %v", m4aDir, err)
+	fmt.Printf("Preview clip cache directory: %s\n", previewDir)
+	fmt.Printf("HLS cache directory: %s\n", hlsDir)
+	fmt.Printf("Spectrogram cache directory: %s\n", spectrogramDir)
+	fmt.Printf("Tagged output cache directory: %s\n", taggedDir)
+	fmt.Printf("Place photo directory: %s\n", placePhotoDir)
+
+	for _, dir := range []string{jsonDir, m4aDir, previewDir, hlsDir, spectrogramDir, taggedDir, placePhotoDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Failed to create %s directory: %v", dir, err)
+		}
 	}
 
 	fmt.Println("Initializing audio data...")
@@ -65,8 +84,22 @@ func main() {
 	}
 	fmt.Println("Audio time synchronization complete.")
 
-	// --- 根据 -gen 参数决定执行流程 ---
-	if *genFlag {
+	// --- 根据 -duplicates / -geotag / -weather / -gen 参数决定执行流程 ---
+	if *duplicatesFlag {
+		pairs, err := loadDuplicatePairs()
+		if err != nil {
+			log.Fatalf("Failed to load audio metadata: %v", err)
+		}
+		fmt.Print(formatDuplicateReport(pairs))
+	} else if *geotagFlag != "" {
+		if err := runGeotagCommand(*geotagFlag, *folderFlag, *offsetFlag, *maxGapFlag, *applyFlag); err != nil {
+			log.Fatalf("Failed to geotag recordings: %v", err)
+		}
+	} else if *weatherFlag != "" {
+		if err := runWeatherCommand(*weatherFlag, *folderFlag, *applyFlag); err != nil {
+			log.Fatalf("Failed to import weather: %v", err)
+		}
+	} else if *genFlag {
 		// 直接生成并退出
 		fmt.Println("Generation-only mode activated.")
 		if err := runGenerationLogic(); err != nil {
@@ -99,6 +132,23 @@ func startWebServer() {
 	http.HandleFunc("/edit-folder", editFolderHandler)
 	http.HandleFunc("/save-folder", saveFolderHandler)
 	http.HandleFunc("/delete", deleteHandler)
+	http.HandleFunc("/source-audio", sourceAudioHandler)
+	http.HandleFunc("/sync-source", syncSourceHandler)
+	http.HandleFunc("/confirm-split", confirmSplitHandler)
+	http.HandleFunc("/review-event", reviewEventHandler)
+	http.HandleFunc("/review-analysis", reviewAnalysisHandler)
+	http.HandleFunc("/duplicates", duplicatesHandler)
+	http.HandleFunc("/merge-metadata", mergeMetadataHandler)
+	http.HandleFunc("/geotag", geotagHandler)
+	http.HandleFunc("/reverse-geocode", reverseGeocodeHandler)
+	http.HandleFunc("/apply-geotag", applyGeotagHandler)
+	http.HandleFunc("/weather", weatherHandler)
+	http.HandleFunc("/apply-weather", applyWeatherHandler)
+	http.HandleFunc("/places", placesHandler)
+	http.HandleFunc("/edit-place", editPlaceHandler)
+	http.HandleFunc("/save-place", savePlaceHandler)
+	http.HandleFunc("/delete-place", deletePlaceHandler)
+	http.HandleFunc("/place-photo", placePhotoHandler)
 	http.HandleFunc("/generate", generateStaticSiteHandler)
 	http.Handle("/site/", http.StripPrefix("/site/", http.FileServer(http.Dir(distDir))))
 	fmt.Println("Admin server starting on http://localhost:8080")
@@ -171,7 +221,14 @@ func saveAboutHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func adminHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("admin.html").Funcs(template.FuncMap{"Base": filepath.Base, "formatDuration": formatDuration}).ParseFS(templateFS, "templates/admin.html")
+	settings, err := loadSettings()
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	qcWarnings := func(report *QCReport) []string { return report.problems(settings.QC) }
+	tmpl, err := template.New("admin.html").Funcs(template.FuncMap{"Base": filepath.Base, "formatDuration": formatDuration, "qcWarnings": qcWarnings}).ParseFS(templateFS, "templates/admin.html")
 	if err != nil {
 		log.Printf("Error parsing template admin.html: %v", err)
 		http.Error(w, "Internal Server Error", 500)
@@ -213,12 +270,26 @@ func editHandler(w http.ResponseWriter, r *http.Request) {
 	// Prepare data for the template
 	ext := filepath.Ext(metadata.SourceFilename)
 	data := EditPageData{
-		AudioMetadata: metadata,
-		BaseFilename:  strings.TrimSuffix(filepath.Base(metadata.SourceFilename), ext),
-		FolderPath:    filepath.Dir(metadata.SourceFilename),
+		AudioMetadata:     metadata,
+		BaseFilename:      strings.TrimSuffix(filepath.Base(metadata.SourceFilename), ext),
+		FolderPath:        filepath.Dir(metadata.SourceFilename),
+		ChannelMixOptions: channelMixOptions(metadata.TechInfo.Channels, metadata.TechInfo.ChannelNames),
+	}
+	data.SourceFormat, _ = sourceFormatFor(metadata.SourceFilename)
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
 	}
+	data.PlaceLabels = places.labels()
+	data.LocationLabel = places.locationLabel(metadata)
+	data.PrivacyLevels = privacyLevels
+	published, _ := effectivePrivacy(metadata, places)
+	data.PublishedPrivacy = published.Label()
+	data.RunningAnalyzers = runningExternalAnalyzers(metadata.SourceFilename)
 
-	tmpl, err := template.New("edit.html").Funcs(template.FuncMap{"Base": filepath.Base}).ParseFS(templateFS, "templates/edit.html")
+	tmpl, err := template.New("edit.html").Funcs(template.FuncMap{"Base": filepath.Base, "timecode": formatTimecode, "sub": func(a, b float64) float64 { return a - b }}).ParseFS(templateFS, "templates/edit.html", "templates/address_suggest.html")
 	if err != nil {
 		log.Printf("Error parsing template edit.html: %v", err)
 		http.Error(w, "Internal Server Error", 500)
@@ -254,20 +325,33 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 	if newSourceFilename != oldSourceFilename {
 		log.Printf("Rename requested: %s -> %s", oldSourceFilename, newSourceFilename)
 
+		if metadata, err := loadAudioMetadata(filepath.Join(jsonDir, sidecarBase(oldSourceFilename)+".json")); err == nil && len(metadata.SplitParts) > 1 {
+			http.Error(w, "Split recordings keep the recorder's file names and can't be renamed", http.StatusBadRequest)
+			return
+		}
+		// Sidecars are named without the extension, so "a.flac" can't be renamed next to "a.wav"
+		for _, existing := range findSourceFiles(sidecarBase(newSourceFilename)) {
+			if !sameFile(filepath.Join(wavDir, existing), filepath.Join(wavDir, oldSourceFilename)) {
+				http.Error(w, fmt.Sprintf("Failed to rename: %s already uses this name", existing), http.StatusConflict)
+				return
+			}
+		}
+
 		// Define old and new paths for all related files
 		oldWavPath := filepath.Join(wavDir, oldSourceFilename)
 		newWavPath := filepath.Join(wavDir, newSourceFilename)
-		oldJsonPath := filepath.Join(jsonDir, strings.TrimSuffix(oldSourceFilename, ext)+".json")
-		newJsonPath := filepath.Join(jsonDir, strings.TrimSuffix(newSourceFilename, ext)+".json")
-		oldM4aPath := filepath.Join(m4aDir, strings.TrimSuffix(oldSourceFilename, ext)+".m4a")
-		newM4aPath := filepath.Join(m4aDir, strings.TrimSuffix(newSourceFilename, ext)+".m4a")
+		oldJsonPath := filepath.Join(jsonDir, sidecarBase(oldSourceFilename)+".json")
+		newJsonPath := filepath.Join(jsonDir, sidecarBase(newSourceFilename)+".json")
+		oldM4aRelPath := sidecarBase(oldSourceFilename) + ".m4a"
+		newM4aRelPath := sidecarBase(newSourceFilename) + ".m4a"
 
 		// Helper function to safely rename a file if it exists, and handle target existence
 		safeRename := func(oldPath, newPath string, isCritical bool) error {
 			_, oldPathExistsErr := os.Stat(oldPath)
 			_, newPathExistsErr := os.Stat(newPath)
 
-			if !os.IsNotExist(newPathExistsErr) {
+			// On case-insensitive file systems a case-only rename finds the old file at the new path
+			if !os.IsNotExist(newPathExistsErr) && !sameFile(oldPath, newPath) {
 				// Target file already exists and is not an "does not exist" error
 				return fmt.Errorf("target file %s already exists", newPath)
 			}
@@ -287,8 +371,8 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Perform renames with error handling
 		if err := safeRename(oldWavPath, newWavPath, true); err != nil {
-			log.Printf("Error renaming WAV file: %v", err)
-			http.Error(w, fmt.Sprintf("Failed to rename WAV file: %v", err), http.StatusInternalServerError)
+			log.Printf("Error renaming source file: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to rename source file: %v", err), http.StatusInternalServerError)
 			return
 		}
 		if err := safeRename(oldJsonPath, newJsonPath, true); err != nil {
@@ -296,17 +380,42 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to rename JSON metadata file: %v", err), http.StatusInternalServerError)
 			return
 		}
-		// M4A is cache, if it fails to rename, it's not critical enough to fail the whole save.
+		// M4A and preview clips are cache, if they fail to rename, it's not critical enough to fail the whole save.
 		// Just log a warning and continue.
-		if err := safeRename(oldM4aPath, newM4aPath, false); err != nil {
-			log.Printf("Warning: Failed to rename M4A cache file: %v", err)
+		for _, cacheDir := range []string{m4aDir, previewDir} {
+			if err := safeRename(filepath.Join(cacheDir, oldM4aRelPath), filepath.Join(cacheDir, newM4aRelPath), false); err != nil {
+				log.Printf("Warning: Failed to rename cache file in %s: %v", cacheDir, err)
+			} else if err := updateCacheManifest(cacheDir, func(m *CacheManifest) {
+				m.rename(oldM4aRelPath, newM4aRelPath)
+			}); err != nil {
+				log.Printf("Warning: Failed to update cache manifest in %s: %v", cacheDir, err)
+			}
+		}
+		removeTaggedCache(oldM4aRelPath)
+		oldHLSRelDir := sidecarBase(oldSourceFilename)
+		newHLSRelDir := sidecarBase(newSourceFilename)
+		if err := safeRename(filepath.Join(hlsDir, oldHLSRelDir), filepath.Join(hlsDir, newHLSRelDir), false); err != nil {
+			log.Printf("Warning: Failed to rename HLS cache: %v", err)
+		} else if err := updateCacheManifest(hlsDir, func(m *CacheManifest) {
+			m.rename(oldHLSRelDir, newHLSRelDir)
+		}); err != nil {
+			log.Printf("Warning: Failed to update HLS cache manifest: %v", err)
+		}
+		oldSpectrogramRelPath := spectrogramCachePath(oldSourceFilename)
+		newSpectrogramRelPath := spectrogramCachePath(newSourceFilename)
+		if err := safeRename(filepath.Join(spectrogramDir, oldSpectrogramRelPath), filepath.Join(spectrogramDir, newSpectrogramRelPath), false); err != nil {
+			log.Printf("Warning: Failed to rename spectrogram cache: %v", err)
+		} else if err := updateCacheManifest(spectrogramDir, func(m *CacheManifest) {
+			m.rename(oldSpectrogramRelPath, newSpectrogramRelPath)
+		}); err != nil {
+			log.Printf("Warning: Failed to update spectrogram cache manifest: %v", err)
 		}
 
 		currentSourceFilename = newSourceFilename
 	}
 
 	// --- Load and Update Metadata ---
-	jsonFileRelPath := strings.TrimSuffix(currentSourceFilename, ext) + ".json"
+	jsonFileRelPath := sidecarBase(currentSourceFilename) + ".json"
 	jsonFilePath := filepath.Join(jsonDir, jsonFileRelPath)
 
 	metadata, err := loadAudioMetadata(jsonFilePath)
@@ -318,11 +427,44 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Update metadata from form
 	metadata.SourceFilename = currentSourceFilename // Update to new filename if changed
-	aacRelPath := strings.TrimSuffix(currentSourceFilename, ext) + ".m4a"
+	aacRelPath := sidecarBase(currentSourceFilename) + ".m4a"
 	metadata.CompressedAudioPath = filepath.ToSlash(filepath.Join("assets", "audio", aacRelPath))
 	metadata.Title = strings.ReplaceAll(r.FormValue("title"), "\r", "")
 	metadata.Description = strings.ReplaceAll(r.FormValue("description"), "\r", "")
-	metadata.Location = strings.ReplaceAll(r.FormValue("location"), "\r", "")
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	metadata.PlaceID, metadata.Location = places.resolve(strings.ReplaceAll(r.FormValue("location"), "\r", ""))
+	metadata.Privacy = parsePrivacyFormValue(r)
+	metadata.Edit = EditPoints{
+		TrimInSeconds:  parseFloatFormValue(r, "trim_in_seconds"),
+		TrimOutSeconds: parseFloatFormValue(r, "trim_out_seconds"),
+		FadeInSeconds:  parseFloatFormValue(r, "fade_in_seconds"),
+		FadeOutSeconds: parseFloatFormValue(r, "fade_out_seconds"),
+	}.normalize()
+	metadata.ChannelMix = r.FormValue("channel_mix")
+	metadata.Recorder = strings.TrimSpace(r.FormValue("recorder"))
+	metadata.Artist = strings.TrimSpace(r.FormValue("artist"))
+	metadata.License = strings.TrimSpace(r.FormValue("license"))
+	metadata.CoverImage = strings.TrimSpace(r.FormValue("cover_image"))
+	metadata.Latitude, metadata.Longitude = parseCoordinatesFormValue(r)
+	metadata.Address = parseAddressFormValue(r)
+	metadata.Markers = parseMarkersFormValue(r)
+	if metadata.Levels != nil {
+		if settings, err := loadSettings(); err != nil {
+			log.Printf("Warning: Failed to load settings: %v", err)
+		} else {
+			metadata.Levels.applyCalibration(settings, metadata.Recorder)
+		}
+	}
+	metadata.PreviewStartSeconds = nil
+	if v := strings.TrimSpace(r.FormValue("preview_start_seconds")); v != "" {
+		start := math.Max(parseFloatFormValue(r, "preview_start_seconds"), 0)
+		metadata.PreviewStartSeconds = &start
+	}
 
 	// --- Handle Time Change ---
 	newRecordDateStr := r.FormValue("record_date_date")
@@ -340,6 +482,8 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	refreshSkyContext(&metadata)
+
 	// --- Save Final Metadata ---
 	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
 	if err != nil {
@@ -370,8 +514,14 @@ func editFolderHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Folder not found or is empty", 404)
 		return
 	}
-	currentLocation := filesInFolder[0].Location
-	tmpl, err := template.ParseFS(templateFS, "templates/edit_folder.html")
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	currentLocation := places.locationLabel(filesInFolder[0])
+	tmpl, err := template.ParseFS(templateFS, "templates/edit_folder.html", "templates/address_suggest.html")
 	if err != nil {
 		http.Error(w, "Internal Server Error", 500)
 		return
@@ -379,7 +529,22 @@ func editFolderHandler(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		Path            string
 		CurrentLocation string
-	}{Path: folderPath, CurrentLocation: currentLocation}
+		PlaceLabels     []string
+		CurrentAddress  *GeoAddress
+		Latitude        *float64 // 文件夹中有坐标的录音的平均位置，用于推荐地址
+		Longitude       *float64
+	}{Path: folderPath, CurrentLocation: currentLocation, PlaceLabels: places.labels(), CurrentAddress: filesInFolder[0].Address}
+	var sumLat, sumLon float64
+	var n int
+	for _, meta := range filesInFolder {
+		if meta.Latitude != nil && meta.Longitude != nil {
+			sumLat, sumLon, n = sumLat+*meta.Latitude, sumLon+*meta.Longitude, n+1
+		}
+	}
+	if n > 0 {
+		lat, lon := sumLat/float64(n), sumLon/float64(n)
+		data.Latitude, data.Longitude = &lat, &lon
+	}
 	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "Internal Server Error", 500)
 	}
@@ -391,7 +556,14 @@ func saveFolderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	folderPath := r.FormValue("path")
-	newLocation := strings.ReplaceAll(r.FormValue("location"), "\r", "")
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	newPlaceID, newLocation := places.resolve(strings.ReplaceAll(r.FormValue("location"), "\r", ""))
+	newAddress := parseAddressFormValue(r) // 为空时保留各录音原有的地址
 	if folderPath == "" {
 		http.Error(w, "Folder path is missing", 400)
 		return
@@ -410,7 +582,11 @@ func saveFolderHandler(w http.ResponseWriter, r *http.Request) {
 				return nil // Continue to next file
 			}
 			if filepath.Dir(metadata.SourceFilename) == folderPath {
-				metadata.Location = newLocation
+				metadata.PlaceID, metadata.Location = newPlaceID, newLocation
+				if newAddress != nil {
+					address := *newAddress
+					metadata.Address = &address
+				}
 				updatedJson, err := json.MarshalIndent(metadata, "", "  ")
 				if err != nil {
 					log.Printf("Failed to marshal json for %s: %v", metadata.SourceFilename, err)
@@ -430,6 +606,534 @@ func saveFolderHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// PlaceListItem 是地点管理页中的一行
+type PlaceListItem struct {
+	Place
+	Label      string
+	Recordings int // 直接引用该地点的录音数
+}
+
+// placesHandler 列出地点登记表中的所有地点
+func placesHandler(w http.ResponseWriter, r *http.Request) {
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	grouped, err := loadAllMetadataGroupedByFolder()
+	if err != nil {
+		http.Error(w, "Failed to load metadata", 500)
+		return
+	}
+	counts := map[string]int{}
+	for _, files := range grouped {
+		for _, meta := range files {
+			counts[meta.PlaceID]++
+		}
+	}
+	items := make([]PlaceListItem, 0, len(places))
+	for _, p := range places {
+		items = append(items, PlaceListItem{Place: p, Label: places.label(p.ID), Recordings: counts[p.ID]})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	tmpl, err := template.ParseFS(templateFS, "templates/places.html")
+	if err != nil {
+		log.Printf("Error parsing template places.html: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	if err := tmpl.Execute(w, items); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+	}
+}
+
+// editPlaceHandler 显示地点的编辑表单，没有 id 参数时新建地点
+func editPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	data := struct {
+		Place
+		IsNew         bool
+		Parents       []PlaceListItem // 可选的上级区域，不含该地点本身及其下级
+		Recordings    []AudioMetadata
+		PrivacyLevels []struct{ Key, Label string }
+	}{IsNew: true, PrivacyLevels: privacyLevels}
+	if id := r.URL.Query().Get("id"); id != "" {
+		p := places.byID(id)
+		if p == nil {
+			http.Error(w, "Place not found", 404)
+			return
+		}
+		data.Place, data.IsNew = *p, false
+		grouped, err := loadAllMetadataGroupedByFolder()
+		if err != nil {
+			http.Error(w, "Failed to load metadata", 500)
+			return
+		}
+		for _, files := range grouped {
+			for _, meta := range files {
+				if meta.PlaceID == id {
+					data.Recordings = append(data.Recordings, meta)
+				}
+			}
+		}
+		sort.Slice(data.Recordings, func(i, j int) bool { return data.Recordings[i].RecordDate.After(data.Recordings[j].RecordDate) })
+	}
+	for _, p := range places {
+		if data.IsNew || !places.isWithin(p.ID, data.ID) {
+			data.Parents = append(data.Parents, PlaceListItem{Place: p, Label: places.label(p.ID)})
+		}
+	}
+	sort.Slice(data.Parents, func(i, j int) bool { return data.Parents[i].Label < data.Parents[j].Label })
+	tmpl, err := template.ParseFS(templateFS, "templates/edit_place.html")
+	if err != nil {
+		log.Printf("Error parsing template edit_place.html: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+	}
+}
+
+// savePlaceHandler 保存地点，改名时同步更新所有引用它的录音
+func savePlaceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Place name is missing", http.StatusBadRequest)
+		return
+	}
+	var place *Place
+	if id := r.FormValue("id"); id != "" {
+		if place = places.byID(id); place == nil {
+			http.Error(w, "Place not found", 404)
+			return
+		}
+	} else {
+		places = append(places, Place{ID: places.newID()})
+		place = &places[len(places)-1]
+	}
+	parentID := r.FormValue("parent_id")
+	if parentID != "" && (places.byID(parentID) == nil || places.isWithin(parentID, place.ID)) {
+		http.Error(w, "A place can't be inside itself or one of its own sub-places", http.StatusBadRequest)
+		return
+	}
+	renamed := place.Name != "" && place.Name != name
+	place.Name, place.ParentID = name, parentID
+	place.Description = strings.ReplaceAll(r.FormValue("description"), "\r", "")
+	place.Latitude, place.Longitude = parseCoordinatesFormValue(r)
+	place.Privacy = parsePrivacyFormValue(r)
+
+	removed := r.Form["remove_photo"]
+	var photos []string
+	for _, photo := range place.Photos {
+		if containsString(removed, photo) {
+			if err := os.Remove(placePhotoPath(place.ID, photo)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: failed to delete photo %s: %v", photo, err)
+			}
+			continue
+		}
+		photos = append(photos, photo)
+	}
+	for _, header := range r.MultipartForm.File["photos"] {
+		photo, err := savePlacePhoto(place.ID, header)
+		if err != nil {
+			log.Printf("Error saving photo for place %s: %v", place.Name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !containsString(photos, photo) {
+			photos = append(photos, photo)
+		}
+	}
+	place.Photos = photos
+
+	if err := places.save(); err != nil {
+		log.Printf("Error saving places: %v", err)
+		http.Error(w, "Failed to save place", 500)
+		return
+	}
+	if renamed {
+		if err := propagatePlaceName(*place); err != nil {
+			log.Printf("Error renaming place %s in recordings: %v", place.ID, err)
+			http.Error(w, "Place saved, but updating its recordings failed", 500)
+			return
+		}
+	}
+	http.Redirect(w, r, "/places", http.StatusSeeOther)
+}
+
+// deletePlaceHandler 删除没有被录音或下级地点引用的地点
+func deletePlaceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.FormValue("id")
+	places, err := loadPlaces()
+	if err != nil {
+		log.Printf("Error loading places: %v", err)
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	if places.byID(id) == nil {
+		http.Error(w, "Place not found", 404)
+		return
+	}
+	if len(places.children(id)) > 0 {
+		http.Error(w, "Move or delete the sub-places of this place first", http.StatusBadRequest)
+		return
+	}
+	grouped, err := loadAllMetadataGroupedByFolder()
+	if err != nil {
+		http.Error(w, "Failed to load metadata", 500)
+		return
+	}
+	for _, files := range grouped {
+		for _, meta := range files {
+			if meta.PlaceID == id {
+				http.Error(w, "Recordings still refer to this place, move them to another place first", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	var remaining placeRegistry
+	for _, p := range places {
+		if p.ID != id {
+			remaining = append(remaining, p)
+		}
+	}
+	if err := remaining.save(); err != nil {
+		log.Printf("Error saving places: %v", err)
+		http.Error(w, "Failed to delete place", 500)
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(placePhotoDir, id)); err != nil {
+		log.Printf("Warning: failed to delete photos of place %s: %v", id, err)
+	}
+	http.Redirect(w, r, "/places", http.StatusSeeOther)
+}
+
+// placePhotoHandler 提供地点照片的访问
+func placePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	places, err := loadPlaces()
+	if err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	p := places.byID(r.URL.Query().Get("id"))
+	name := r.URL.Query().Get("name")
+	if p == nil || !containsString(p.Photos, name) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, placePhotoPath(p.ID, name))
+}
+
+// reverseGeocodeHandler 根据 lat/lon 参数用本地地名表推荐地址，返回 JSON，附近没有地点时为 null
+func reverseGeocodeHandler(w http.ResponseWriter, r *http.Request) {
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if errLat != nil || errLon != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if settings.Gazetteer.Path == "" {
+		http.Error(w, "No gazetteer configured, set gazetteer.path in settings.json", http.StatusNotFound)
+		return
+	}
+	suggestion, err := suggestAddress(settings.Gazetteer, lat, lon)
+	if err != nil {
+		log.Printf("Error looking up address: %v", err)
+		http.Error(w, "Failed to read gazetteer", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(suggestion); err != nil {
+		log.Printf("Error writing address suggestion: %v", err)
+	}
+}
+
+// sourceAudioHandler 提供源文件的访问，供编辑页试听裁剪与淡入淡出效果
+func sourceAudioHandler(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Filename parameter is missing", http.StatusBadRequest)
+		return
+	}
+	// Clean against a rooted path so the request can't escape wavDir
+	relPath := filepath.Clean("/" + filename)[1:]
+	// A split recording is previewed as a whole, so edit points can be set anywhere in it
+	if metadata, err := getMetadataBySourceFilename(relPath); err == nil && metadata.SourceFilename == relPath && len(metadata.SplitParts) > 1 {
+		if err := serveSplitAudio(w, r, metadata); err != nil {
+			log.Printf("Error serving split recording %s: %v", relPath, err)
+			http.Error(w, "Failed to join split recording", http.StatusInternalServerError)
+		}
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(wavDir, relPath))
+}
+
+// confirmSplitHandler 确认或否认扫描发现的分段录音。确认后各段合并为第一段的一条录音，
+// 其余分段自己的元数据文件被删除；否认后不再提示同一分组
+func confirmSplitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := r.FormValue("filename")
+	metadata, err := getMetadataBySourceFilename(filename)
+	if err != nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	if len(metadata.ProposedSplitParts) < 2 {
+		http.Error(w, "No split recording is waiting for confirmation", http.StatusBadRequest)
+		return
+	}
+	switch r.FormValue("action") {
+	case "confirm":
+		duration, sizeMB, err := splitSetTotals(metadata.ProposedSplitParts)
+		if err != nil {
+			log.Printf("Error reading split parts of %s: %v", metadata.SourceFilename, err)
+			http.Error(w, fmt.Sprintf("Failed to read split parts: %v", err), http.StatusInternalServerError)
+			return
+		}
+		metadata.SplitParts, metadata.ProposedSplitParts = metadata.ProposedSplitParts, nil
+		metadata.DurationSeconds, metadata.SourceFileSizeMB = duration, sizeMB
+		for _, part := range metadata.SplitParts[1:] {
+			ownJsonPath := filepath.Join(jsonDir, sidecarBase(part)+".json")
+			if own, err := loadAudioMetadata(ownJsonPath); err == nil && own.SourceFilename == part {
+				if err := os.Remove(ownJsonPath); err != nil {
+					log.Printf("Warning: Failed to remove %s: %v", ownJsonPath, err)
+				}
+			}
+		}
+		log.Printf("Joining split recording %s (%d parts)", metadata.SourceFilename, len(metadata.SplitParts))
+	case "decline":
+		metadata.DeclinedSplitParts, metadata.ProposedSplitParts = metadata.ProposedSplitParts, nil
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	jsonFilePath := filepath.Join(jsonDir, sidecarBase(metadata.SourceFilename)+".json")
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(jsonFilePath, updatedJsonContent, 0644); err != nil {
+		log.Printf("Failed to write json file %s: %v", jsonFilePath, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/edit?filename="+url.QueryEscape(metadata.SourceFilename), http.StatusSeeOther)
+}
+
+// syncSourceHandler 把 JSON 中的元数据写回源 WAV 的 bext、LIST-INFO 和 iXML 块
+func syncSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := r.FormValue("filename")
+	if filename == "" {
+		http.Error(w, "Filename parameter is missing", http.StatusBadRequest)
+		return
+	}
+	metadata, err := getMetadataBySourceFilename(filename)
+	if err != nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	if format, _ := sourceFormatFor(metadata.SourceFilename); !format.RIFF {
+		http.Error(w, "Only WAV sources can carry BWF metadata", http.StatusBadRequest)
+		return
+	}
+	// Each part of a split recording gets its own start time
+	partMeta := metadata
+	for _, wavPath := range metadata.SourcePaths() {
+		if err := writeBWFMetadata(wavPath, partMeta); err != nil {
+			log.Printf("Failed to write metadata into %s: %v", wavPath, err)
+			http.Error(w, fmt.Sprintf("Failed to write metadata into source file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Wrote metadata into %s", wavPath)
+		if duration, _, _, _, err := getAudioTechInfo(wavPath); err == nil {
+			partMeta.RecordDate = partMeta.RecordDate.Add(time.Duration(duration * float64(time.Second)))
+		}
+	}
+
+	// Only metadata chunks changed, carry the analyses and cached transcode over to the new file hash
+	if err := rebaseSourceHash(&metadata); err != nil {
+		log.Printf("Warning: Failed to update source hash for %s: %v", metadata.SourceFilename, err)
+	}
+	jsonFilePath := filepath.Join(jsonDir, sidecarBase(metadata.SourceFilename)+".json")
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(jsonFilePath, updatedJsonContent, 0644); err != nil {
+		log.Printf("Failed to write json file %s: %v", jsonFilePath, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/edit?filename="+url.QueryEscape(metadata.SourceFilename), http.StatusSeeOther)
+}
+
+// reviewEventHandler 接受或拒绝自动检测的候选事件，接受的事件转为手动标记。
+// index 为 "all" 时处理所有未审核的事件
+func reviewEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := r.FormValue("filename")
+	action := r.FormValue("action")
+	if filename == "" || (action != eventAccepted && action != eventRejected) {
+		http.Error(w, "Filename or action parameter is missing", http.StatusBadRequest)
+		return
+	}
+	jsonFilePath := filepath.Join(jsonDir, sidecarBase(filename)+".json")
+	metadata, err := loadAudioMetadata(jsonFilePath)
+	if err != nil || metadata.Events == nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	var selected []int
+	if index := r.FormValue("index"); index == "all" {
+		for i, e := range metadata.Events.Events {
+			if e.Status == "" {
+				selected = append(selected, i)
+			}
+		}
+	} else if i, err := strconv.Atoi(index); err == nil && i >= 0 && i < len(metadata.Events.Events) {
+		selected = append(selected, i)
+	} else {
+		http.Error(w, "Invalid event index", http.StatusBadRequest)
+		return
+	}
+	for _, i := range selected {
+		event := &metadata.Events.Events[i]
+		if event.Status == action {
+			continue
+		}
+		event.Status = action
+		if action == eventAccepted {
+			metadata.Markers = append(metadata.Markers, Marker{
+				TimeSeconds:     event.StartSeconds,
+				DurationSeconds: event.EndSeconds - event.StartSeconds,
+				Label:           "检测到的事件",
+				Note:            fmt.Sprintf("峰值 %.1f dBFS", event.PeakDBFS),
+			})
+		}
+	}
+	sortMarkers(metadata.Markers)
+
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal json for %s: %v", filename, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(jsonFilePath, updatedJsonContent, 0644); err != nil {
+		log.Printf("Failed to write json file %s: %v", jsonFilePath, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/edit?filename="+url.QueryEscape(metadata.SourceFilename)+"#events", http.StatusSeeOther)
+}
+
+// reviewAnalysisHandler 接受或拒绝一个外部分析器的结果，或在后台重新运行该分析器
+func reviewAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := r.FormValue("filename")
+	name := r.FormValue("analyzer")
+	action := r.FormValue("action")
+	if filename == "" || name == "" {
+		http.Error(w, "Filename or analyzer parameter is missing", http.StatusBadRequest)
+		return
+	}
+	jsonFilePath := filepath.Join(jsonDir, sidecarBase(filename)+".json")
+	metadata, err := loadAudioMetadata(jsonFilePath)
+	if err != nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	switch action {
+	case eventAccepted, eventRejected:
+		result, ok := metadata.External[name]
+		if !ok || result == nil || result.Error != "" {
+			http.Error(w, "No result from this analyzer", http.StatusNotFound)
+			return
+		}
+		result.Status = action
+	case "rerun":
+		settings, err := loadSettings()
+		if err != nil {
+			http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+			return
+		}
+		for _, analyzer := range settings.Analyzers {
+			if analyzer.Name == name && len(analyzer.Command) > 0 {
+				// The analyzer may run for minutes, so it runs in the background and saves its own result
+				rerunExternalAnalyzer(metadata, jsonFilePath, analyzer)
+				http.Redirect(w, r, "/edit?filename="+url.QueryEscape(metadata.SourceFilename)+"#analyses", http.StatusSeeOther)
+				return
+			}
+		}
+		http.Error(w, "Analyzer is not configured in settings.json", http.StatusNotFound)
+		return
+	default:
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal json for %s: %v", filename, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(jsonFilePath, updatedJsonContent, 0644); err != nil {
+		log.Printf("Failed to write json file %s: %v", jsonFilePath, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/edit?filename="+url.QueryEscape(metadata.SourceFilename)+"#analyses", http.StatusSeeOther)
+}
+
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
@@ -443,11 +1147,20 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Construct file paths
 	wavPath := filepath.Join(wavDir, sourceFilename)
-	jsonPath := filepath.Join(jsonDir, strings.TrimSuffix(sourceFilename, filepath.Ext(sourceFilename))+".json")
-	m4aPath := filepath.Join(m4aDir, strings.TrimSuffix(sourceFilename, filepath.Ext(sourceFilename))+".m4a")
-
-	// Delete the files
-	filesToDelete := []string{wavPath, jsonPath, m4aPath}
+	jsonPath := filepath.Join(jsonDir, sidecarBase(sourceFilename)+".json")
+	m4aRelPath := sidecarBase(sourceFilename) + ".m4a"
+	m4aPath := filepath.Join(m4aDir, m4aRelPath)
+	previewPath := filepath.Join(previewDir, m4aRelPath)
+	hlsRelDir := sidecarBase(sourceFilename)
+	hlsPath := filepath.Join(hlsDir, hlsRelDir)
+	spectrogramRelPath := spectrogramCachePath(sourceFilename)
+	spectrogramPath := filepath.Join(spectrogramDir, spectrogramRelPath)
+
+	// Delete the files, including every part of a split recording
+	filesToDelete := []string{wavPath, jsonPath, m4aPath, previewPath, spectrogramPath}
+	if metadata, err := loadAudioMetadata(jsonPath); err == nil && len(metadata.SplitParts) > 1 {
+		filesToDelete = append(filesToDelete, metadata.SourcePaths()[1:]...)
+	}
 	for _, path := range filesToDelete {
 		err := os.Remove(path)
 		if err != nil && !os.IsNotExist(err) {
@@ -458,9 +1171,32 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := os.RemoveAll(hlsPath); err != nil {
+		log.Printf("Failed to delete HLS cache %s: %v", hlsPath, err)
+	}
+	removeTaggedCache(m4aRelPath)
+
+	for _, cacheDir := range []string{m4aDir, previewDir} {
+		if err := updateCacheManifest(cacheDir, func(m *CacheManifest) {
+			m.remove(m4aRelPath)
+		}); err != nil {
+			log.Printf("Failed to update cache manifest in %s: %v", cacheDir, err)
+		}
+	}
+	if err := updateCacheManifest(hlsDir, func(m *CacheManifest) {
+		m.remove(hlsRelDir)
+	}); err != nil {
+		log.Printf("Failed to update HLS cache manifest: %v", err)
+	}
+	if err := updateCacheManifest(spectrogramDir, func(m *CacheManifest) {
+		m.remove(spectrogramRelPath)
+	}); err != nil {
+		log.Printf("Failed to update spectrogram cache manifest: %v", err)
+	}
+
 	// Check and delete parent directories if they are empty
-	dirsToCheck := []string{filepath.Dir(wavPath), filepath.Dir(jsonPath), filepath.Dir(m4aPath)}
-	rootDirs := []string{wavDir, jsonDir, m4aDir}
+	dirsToCheck := []string{filepath.Dir(wavPath), filepath.Dir(jsonPath), filepath.Dir(m4aPath), filepath.Dir(previewPath), filepath.Dir(hlsPath), filepath.Dir(spectrogramPath)}
+	rootDirs := []string{wavDir, jsonDir, m4aDir, previewDir, hlsDir, spectrogramDir}
 	for i, dir := range dirsToCheck {
 		// Ensure we don't delete the root data directories
 		if dir != "." && dir != "/" && dir != rootDirs[i] {
@@ -475,6 +1211,298 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if r.FormValue("return") == "duplicates" {
+		http.Redirect(w, r, "/duplicates", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// duplicatesHandler 列出完全相同和几乎相同的录音
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	pairs, err := loadDuplicatePairs()
+	if err != nil {
+		http.Error(w, "Failed to load metadata", http.StatusInternalServerError)
+		return
+	}
+	tmpl, err := template.New("duplicates.html").Funcs(template.FuncMap{
+		"formatDuration": formatDuration,
+		"neg":            func(f float64) float64 { return -f },
+		"mul100":         func(f float64) float64 { return f * 100 },
+	}).ParseFS(templateFS, "templates/duplicates.html")
+	if err != nil {
+		log.Printf("Error parsing duplicates template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, pairs); err != nil {
+		log.Printf("Error executing duplicates template: %v", err)
+	}
+}
+
+// mergeMetadataHandler 把 from 的元数据合并到 into：只填写 into 中为空或仍是默认值的字段，
+// 并加入 into 中没有的手动标记（按两者的偏移换算时间）
+func mergeMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fromName, intoName := r.FormValue("from"), r.FormValue("into")
+	if fromName == "" || intoName == "" || fromName == intoName {
+		http.Error(w, "From or into parameter is missing", http.StatusBadRequest)
+		return
+	}
+	from, err := getMetadataBySourceFilename(fromName)
+	if err != nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	intoPath := filepath.Join(jsonDir, sidecarBase(intoName)+".json")
+	into, err := loadAudioMetadata(intoPath)
+	if err != nil {
+		http.Error(w, "Audio not found", http.StatusNotFound)
+		return
+	}
+	mergeMetadata(&into, from, parseFloatFormValue(r, "offset_seconds"))
+
+	updatedJsonContent, err := json.MarshalIndent(into, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal json for %s: %v", intoName, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(intoPath, updatedJsonContent, 0644); err != nil {
+		log.Printf("Failed to write json file %s: %v", intoPath, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Merged metadata of %s into %s", fromName, intoName)
+	http.Redirect(w, r, "/duplicates", http.StatusSeeOther)
+}
+
+// GeotagPageData 用于向 geotag.html 传递表单参数和预览结果
+type GeotagPageData struct {
+	Path             string
+	FolderRecordings int
+	OffsetSeconds    float64
+	MaxGapMinutes    float64
+	Error            string
+	HasPreview       bool
+	TrackName        string
+	TrackPoints      int
+	TrackStart       time.Time
+	TrackEnd         time.Time
+	Matches          []GeotagMatch
+	MatchedCount     int
+}
+
+// geotagHandler 显示上传 GPX/KML 轨迹的表单；POST 时解析轨迹并预览每条录音将得到的坐标，不保存
+func geotagHandler(w http.ResponseWriter, r *http.Request) {
+	data := GeotagPageData{Path: r.FormValue("path"), MaxGapMinutes: 10}
+	if data.Path == "" {
+		http.Error(w, "Folder path parameter is missing", http.StatusBadRequest)
+		return
+	}
+	recordings, err := folderRecordings(data.Path)
+	if err != nil {
+		http.Error(w, "Folder not found or is empty", http.StatusNotFound)
+		return
+	}
+	data.FolderRecordings = len(recordings)
+
+	if r.Method == http.MethodPost {
+		data.OffsetSeconds = parseFloatFormValue(r, "offset_seconds")
+		if v := parseFloatFormValue(r, "max_gap_minutes"); v > 0 {
+			data.MaxGapMinutes = v
+		}
+		file, header, err := r.FormFile("track")
+		if err != nil {
+			data.Error = "请选择 GPX 或 KML 轨迹文件"
+		} else {
+			defer file.Close()
+			data.TrackName = header.Filename
+			track, err := parseTrack(file)
+			if err != nil {
+				data.Error = fmt.Sprintf("无法读取轨迹: %v", err)
+			} else {
+				data.HasPreview = true
+				data.TrackPoints = len(track)
+				data.TrackStart, data.TrackEnd = track[0].Time.In(getUserTimeLocation()), track[len(track)-1].Time.In(getUserTimeLocation())
+				data.Matches = matchTrack(recordings, track,
+					time.Duration(data.OffsetSeconds*float64(time.Second)),
+					time.Duration(data.MaxGapMinutes*float64(time.Minute)))
+				for _, m := range data.Matches {
+					if m.Matched {
+						data.MatchedCount++
+					}
+				}
+			}
+		}
+	}
+
+	tmpl, err := template.New("geotag.html").Funcs(template.FuncMap{
+		"Base":  filepath.Base,
+		"deref": func(f *float64) float64 { return *f },
+	}).ParseFS(templateFS, "templates/geotag.html")
+	if err != nil {
+		log.Printf("Error parsing geotag template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing geotag template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// applyGeotagHandler 保存预览中勾选的坐标
+func applyGeotagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	selected := r.Form["selected"]
+	filenames, latitudes, longitudes := r.Form["filename"], r.Form["latitude"], r.Form["longitude"]
+	saved := 0
+	for i, filename := range filenames {
+		if i >= len(latitudes) || i >= len(longitudes) || !containsString(selected, filename) {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(latitudes[i], 64)
+		lon, err2 := strconv.ParseFloat(longitudes[i], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if err := applyGeotag(filename, lat, lon); err != nil {
+			log.Printf("Failed to geotag %s: %v", filename, err)
+			http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+			return
+		}
+		saved++
+	}
+	log.Printf("Geotagged %d recordings", saved)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// WeatherPageData 用于向 weather.html 传递表单参数和预览结果
+type WeatherPageData struct {
+	Path             string
+	FolderRecordings int
+	Settings         WeatherSettings
+	Columns          WeatherColumns
+	ToleranceMinutes float64
+	MaxDistanceKm    float64
+	Error            string
+	HasPreview       bool
+	LogName          string
+	LogRows          int
+	LogStart         time.Time
+	LogEnd           time.Time
+	Matches          []WeatherMatch
+	MatchedCount     int
+}
+
+// weatherHandler 上传气象站 CSV 日志并预览文件夹中各录音匹配到的天气
+func weatherHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := loadSettings()
+	if err != nil {
+		log.Printf("Error loading settings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := WeatherPageData{Path: r.FormValue("path"), Settings: settings.Weather, Columns: settings.Weather.columns()}
+	if data.Path == "" {
+		http.Error(w, "Folder path parameter is missing", http.StatusBadRequest)
+		return
+	}
+	recordings, err := folderRecordings(data.Path)
+	if err != nil {
+		http.Error(w, "Folder not found or is empty", http.StatusNotFound)
+		return
+	}
+	data.FolderRecordings = len(recordings)
+	data.ToleranceMinutes = settings.Weather.tolerance().Minutes()
+	data.MaxDistanceKm = settings.Weather.maxDistanceKm()
+
+	if r.Method == http.MethodPost {
+		if v := parseFloatFormValue(r, "tolerance_minutes"); v > 0 {
+			data.ToleranceMinutes = v
+			data.Settings.ToleranceMinutes = v
+		}
+		file, header, err := r.FormFile("log")
+		if err != nil {
+			data.Error = "请选择气象站导出的 CSV 文件"
+		} else {
+			defer file.Close()
+			data.LogName = header.Filename
+			records, err := parseWeatherLog(file, data.Settings)
+			if err != nil {
+				data.Error = fmt.Sprintf("无法读取气象站日志: %v", err)
+			} else {
+				data.HasPreview = true
+				data.LogRows = len(records)
+				data.LogStart, data.LogEnd = records[0].Time, records[len(records)-1].Time
+				data.Matches = matchWeather(recordings, records, filepath.Base(header.Filename), data.Settings)
+				for _, m := range data.Matches {
+					if m.Conditions != nil {
+						data.MatchedCount++
+					}
+				}
+			}
+		}
+	}
+
+	tmpl, err := template.New("weather.html").Funcs(template.FuncMap{
+		"Base": filepath.Base,
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).ParseFS(templateFS, "templates/weather.html")
+	if err != nil {
+		log.Printf("Error parsing weather template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing weather template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// applyWeatherHandler 保存预览中勾选的天气
+func applyWeatherHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	selected := r.Form["selected"]
+	filenames, conditions := r.Form["filename"], r.Form["conditions"]
+	saved := 0
+	for i, filename := range filenames {
+		if i >= len(conditions) || !containsString(selected, filename) {
+			continue
+		}
+		var c WeatherConditions
+		if err := json.Unmarshal([]byte(conditions[i]), &c); err != nil {
+			continue
+		}
+		if err := applyWeather(filename, c); err != nil {
+			log.Printf("Failed to save weather for %s: %v", filename, err)
+			http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+			return
+		}
+		saved++
+	}
+	log.Printf("Saved weather for %d recordings", saved)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -504,19 +1532,58 @@ func runGenerationLogic() error {
 		return flatMetadata[i].RecordDate.After(flatMetadata[j].RecordDate)
 	})
 
+	places, err := loadPlaces()
+	if err != nil {
+		return fmt.Errorf("failed to load places: %w", err)
+	}
+	applyPlaceNames(flatMetadata, places)
+
+	for _, cacheDir := range []string{m4aDir, previewDir, hlsDir, spectrogramDir, taggedDir} {
+		if err := cleanStaleTranscodes(cacheDir); err != nil {
+			log.Printf("Warning: error cleaning stale transcodes in %s: %v", cacheDir, err)
+		}
+	}
+	cacheManifest, err := loadCacheManifest(m4aDir)
+	if err != nil {
+		return fmt.Errorf("failed to load m4a cache manifest: %w", err)
+	}
+	previewManifest, err := loadCacheManifest(previewDir)
+	if err != nil {
+		return fmt.Errorf("failed to load preview cache manifest: %w", err)
+	}
+	hlsManifest, err := loadCacheManifest(hlsDir)
+	if err != nil {
+		return fmt.Errorf("failed to load HLS cache manifest: %w", err)
+	}
+	taggedManifest, err := loadCacheManifest(taggedDir)
+	if err != nil {
+		return fmt.Errorf("failed to load tagged output cache manifest: %w", err)
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		return fmt.Errorf("failed to load settings for static generation: %w", err)
+	}
+
 	var processedMetadata []AudioMetadata // To store only valid, processed metadata
 
 	for i := range flatMetadata {
 		meta := &flatMetadata[i]
-		originalJsonPath := filepath.Join(jsonDir, strings.TrimSuffix(meta.SourceFilename, filepath.Ext(meta.SourceFilename))+".json")
+		originalJsonPath := filepath.Join(jsonDir, sidecarBase(meta.SourceFilename)+".json")
 
-		srcWavPath := filepath.Join(wavDir, meta.SourceFilename)
-		m4aCacheFileRelPath := strings.TrimSuffix(meta.SourceFilename, filepath.Ext(meta.SourceFilename)) + ".m4a"
+		if problems := meta.QC.problems(settings.QC); settings.QC.RefuseToPublish && len(problems) > 0 {
+			log.Printf("Refusing to publish %s, quality check failed: %s", meta.SourceFilename, strings.Join(problems, "; "))
+			continue
+		}
+
+		srcPath := filepath.Join(wavDir, meta.SourceFilename)
+		m4aCacheFileRelPath := sidecarBase(meta.SourceFilename) + ".m4a"
 		m4aCachePath := filepath.Join(m4aDir, m4aCacheFileRelPath)
 
-		wavExists := false
-		if _, err := os.Stat(srcWavPath); err == nil {
-			wavExists = true
+		sourceExists := true
+		for _, path := range meta.SourcePaths() {
+			if _, err := os.Stat(path); err != nil {
+				sourceExists = false
+			}
 		}
 
 		m4aCacheExists := false
@@ -526,44 +1593,76 @@ func runGenerationLogic() error {
 
 		var currentSourcePath string // The path to the M4A file in the cache
 
-		if wavExists {
-			// Scenario 1: WAV file exists - prefer WAV as source
-			srcWavInfo, _ := os.Stat(srcWavPath) // Error already checked by wavExists
-			m4aCacheInfo, m4aCacheErr := os.Stat(m4aCachePath)
+		if sourceExists {
+			// Scenario 1: Source file exists - prefer it over the cache
+			srcInfo, _ := os.Stat(srcPath) // Error already checked by sourceExists
 
-			shouldTranscode := true
-			if m4aCacheErr == nil && !m4aCacheInfo.ModTime().Before(srcWavInfo.ModTime()) {
-				// M4A cache exists and is not older than WAV, no need to transcode
-				shouldTranscode = false
+			// Hashing multi-GB sources is slow, so the stored hash is reused while size and modification time are unchanged
+			hashChanged, err := refreshSourceHash(meta)
+			if err != nil {
+				log.Printf("Error hashing %s: %v. Skipping this audio.", meta.SourceFilename, err)
+				continue
+			}
+			if hashChanged {
+				if stored, err := loadAudioMetadata(originalJsonPath); err == nil {
+					stored.SourceHash, stored.SourceStat = meta.SourceHash, meta.SourceStat
+					if updatedJsonContent, err := json.MarshalIndent(stored, "", "  "); err == nil {
+						if err := os.WriteFile(originalJsonPath, updatedJsonContent, 0644); err != nil {
+							log.Printf("Warning: Failed to write updated JSON file %s: %v", originalJsonPath, err)
+						}
+					}
+				}
 			}
+			sourceHash := meta.SourceHash
+
+			// File times are rewritten to RecordDate by SetBirthTime, so they can't tell us
+			// whether the cache is stale. Compare content hash and encoder fingerprint instead.
+			job := newAacEncodeJob(*meta, settings)
+			fingerprint := job.fingerprint()
+			shouldTranscode := !m4aCacheExists || !cacheManifest.isFresh(m4aCacheFileRelPath, sourceHash, fingerprint)
 
 			if shouldTranscode {
-				// Transcode WAV to M4A cache
+				// Transcode source to M4A cache
 				log.Printf("Transcoding %s to M4A cache...", meta.SourceFilename)
-				if err := transcodeToAac(srcWavPath, m4aCachePath); err != nil {
+				inputPath := srcPath
+				if len(meta.SplitParts) > 1 {
+					if inputPath, err = writeConcatList(meta.SourcePaths()); err != nil {
+						log.Printf("Error preparing split parts of %s: %v. Skipping this audio.", meta.SourceFilename, err)
+						continue
+					}
+				}
+				err := transcodeToAac(inputPath, m4aCachePath, job)
+				if inputPath != srcPath {
+					os.Remove(inputPath)
+				}
+				if err != nil {
 					log.Printf("Error transcoding %s to M4A cache: %v. Skipping this audio.", meta.SourceFilename, err)
 					// If transcoding fails, we can't process this audio
 					continue
 				}
-				// Sync file time from WAV to M4A
-				wavTime := srcWavInfo.ModTime() // Use ModTime for consistent comparison
-				if err := SetBirthTime(m4aCachePath, wavTime); err != nil {
+				// Sync file time from source to M4A
+				srcTime := srcInfo.ModTime() // Use ModTime for consistent comparison
+				if err := SetBirthTime(m4aCachePath, srcTime); err != nil {
 					log.Printf("Warning: Failed to sync file time to M4A cache for %s: %v", m4aCachePath, err)
 				}
+				cacheManifest.record(m4aCacheFileRelPath, sourceHash, fingerprint)
+				if err := cacheManifest.save(); err != nil {
+					log.Printf("Warning: %v", err)
+				}
 			}
 			currentSourcePath = m4aCachePath
 
 		} else if m4aCacheExists {
 
-			// Scenario 2: WAV does not exist, but M4A cache exists - use cached M4A
+			// Scenario 2: Source does not exist, but M4A cache exists - use cached M4A
 
-			log.Printf("WAV not found for %s. Using M4A from cache.", meta.SourceFilename)
+			log.Printf("Source file not found for %s. Using M4A from cache.", meta.SourceFilename)
 
 			currentSourcePath = m4aCachePath
 
 			// If the tech info in the JSON is missing, get it from the M4A file and update JSON
 
-			if meta.TechInfo.SampleRate == 0 || meta.DurationSeconds == 0 {
+			if meta.TechInfo.SampleRate == 0 || (meta.DurationSeconds == 0 && meta.PublishedDurationSeconds == 0) {
 
 				log.Printf("Re-evaluating tech info from M4A cache for %s...", meta.SourceFilename)
 
@@ -575,7 +1674,8 @@ func runGenerationLogic() error {
 
 				} else {
 
-					meta.DurationSeconds = duration
+					// The cache already has the edit points applied, so this is the published length, not the source's
+					meta.PublishedDurationSeconds = duration
 
 					meta.TechInfo.SampleRate = sampleRate
 
@@ -585,7 +1685,7 @@ func runGenerationLogic() error {
 
 					log.Printf("Updating JSON file for %s with info from M4A cache.", meta.SourceFilename)
 
-					originalJsonPath := filepath.Join(jsonDir, strings.TrimSuffix(meta.SourceFilename, filepath.Ext(meta.SourceFilename))+".json")
+					originalJsonPath := filepath.Join(jsonDir, sidecarBase(meta.SourceFilename)+".json")
 
 					updatedJsonContent, err := json.MarshalIndent(meta, "", "  ")
 
@@ -608,40 +1708,73 @@ func runGenerationLogic() error {
 			}
 
 		} else {
-			// Scenario 3: Neither WAV nor M4A cache exists - audio is truly lost
-			log.Printf("Warning: WAV and M4A cache not found for %s. Deleting corresponding JSON: %s", meta.SourceFilename, originalJsonPath)
+			// Scenario 3: Neither source nor M4A cache exists - audio is truly lost
+			log.Printf("Warning: Source file and M4A cache not found for %s. Deleting corresponding JSON: %s", meta.SourceFilename, originalJsonPath)
 			if err := os.Remove(originalJsonPath); err != nil {
 				log.Printf("Error deleting orphan JSON %s: %v", originalJsonPath, err)
 			}
 			continue // Skip this audio
 		}
 
+		// Features describe the published audio, so they are computed from the cache with the edit applied
+		if err := refreshFeatures(meta, currentSourcePath); err != nil {
+			log.Printf("Warning: Failed to compute audio features for %s: %v", meta.SourceFilename, err)
+		}
+
 		// At this point, currentSourcePath points to a valid M4A in the cache
 		// Now copy it to dist/assets/audio and update metadata
 		relPath := m4aCacheFileRelPath // The relative path within assets/audio
 
-		// Use the copyM4aToDist helper
-		compressedAudioPath, err := copyM4aToDist(currentSourcePath, relPath)
+		// Tags are written while copying, so editing them never triggers a re-encode
+		tags := newAudioTags(publicMetadata(*meta, places), settings)
+		compressedAudioPath, err := publishTagged(taggedManifest, currentSourcePath, "audio", relPath, tags)
 		if err != nil {
 			log.Printf("Error copying M4A cache %s to dist: %v. Skipping this audio.", currentSourcePath, err)
 			continue
 		}
 
 		meta.CompressedAudioPath = compressedAudioPath // Relative path for HTML
-		if aacFileInfo, err := os.Stat(currentSourcePath); err == nil {
+		publishedPath := filepath.Join(distDir, filepath.FromSlash(compressedAudioPath))
+		if aacFileInfo, err := os.Stat(publishedPath); err == nil {
 			meta.CompressedFileSizeMB = float64(aacFileInfo.Size()) / (1024 * 1024)
 		} else {
-			log.Printf("Warning: Could not get file info for cached M4A %s: %v", currentSourcePath, err)
+			log.Printf("Warning: Could not get file info for published M4A %s: %v", publishedPath, err)
 			meta.CompressedFileSizeMB = 0
 		}
 
+		// The published file has the edit points applied, so report its length rather than the source's
+		if meta.DurationSeconds > 0 {
+			meta.PublishedDurationSeconds = meta.Edit.outputDuration(meta.DurationSeconds)
+		}
+
+		if previewClipPath, err := buildPreviewClip(previewManifest, taggedManifest, *meta, currentSourcePath, relPath, tags); err != nil {
+			log.Printf("Warning: Failed to build preview clip for %s: %v", meta.SourceFilename, err)
+			meta.PreviewClipPath = ""
+		} else {
+			meta.PreviewClipPath = previewClipPath
+		}
+
+		meta.HLSPlaylistPath = ""
+		if settings.HLS.Enabled && meta.PublishedDurationSeconds >= settings.HLS.minDuration() {
+			if playlistPath, err := buildHLS(hlsManifest, settings.HLS, currentSourcePath, relPath); err != nil {
+				log.Printf("Warning: Failed to build HLS output for %s: %v. Falling back to progressive file.", meta.SourceFilename, err)
+			} else {
+				meta.HLSPlaylistPath = playlistPath
+			}
+		}
+
 		processedMetadata = append(processedMetadata, *meta)
 	}
 
 	// Replace flatMetadata with processedMetadata
 	flatMetadata = processedMetadata
 
-	tmpl, err := template.New("index.html.tmpl").Funcs(template.FuncMap{"Base": filepath.Base, "formatDuration": formatDuration, "add": add}).ParseFS(templateFS, "templates/index.html.tmpl")
+	// Everything published from here on only sees the locations allowed by the privacy settings
+	for i := range flatMetadata {
+		flatMetadata[i] = publicMetadata(flatMetadata[i], places)
+	}
+
+	tmpl, err := template.New("index.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/index.html.tmpl")
 	if err != nil {
 		return fmt.Errorf("failed to parse template index.html.tmpl: %w", err)
 	}
@@ -658,33 +1791,42 @@ func runGenerationLogic() error {
 	}
 	log.Printf("Generated %s", indexPath)
 
+	similar := findSimilarRecordings(flatMetadata)
+	spectrograms, daySpectrograms, err := publishSpectrograms(flatMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to publish spectrograms: %w", err)
+	}
+	if err := generateRecordingPages(flatMetadata, similar, spectrograms); err != nil {
+		return err
+	}
+	if err := generateSpectrogramsPage(daySpectrograms); err != nil {
+		return err
+	}
+	if err := writeSimilarExport(flatMetadata, similar); err != nil {
+		return fmt.Errorf("failed to export similar recordings: %w", err)
+	}
+	if err := writeIndicesExports(flatMetadata); err != nil {
+		return fmt.Errorf("failed to export acoustic indices: %w", err)
+	}
+	if err := generateStatsPage(flatMetadata); err != nil {
+		return err
+	}
+	placeSummaries, rootPlaces := summarizePlaces(flatMetadata, publicPlaces(places))
+	publishPlacePhotos(placeSummaries)
+	if err := generatePlacePages(placeSummaries, rootPlaces); err != nil {
+		return err
+	}
+
 	if err := copyFile("icon.svg", filepath.Join(distDir, "icon.svg")); err != nil {
 		log.Printf("Warning: could not copy icon.svg: %v", err)
 	}
 
-	if err := filepath.Walk(staticDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, _ := filepath.Rel(staticDir, path)
-		if relPath == "." {
-			return nil
-		}
-		destPath := filepath.Join(distDir, relPath)
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
-		return copyFile(path, destPath)
-	}); err != nil {
+	if err := copyDir(staticDir, distDir); err != nil {
 		log.Printf("Warning: error copying static assets: %v", err)
 	}
 
 	// --- SEO File Generation ---
 	log.Println("Generating SEO files...")
-	settings, err := loadSettings()
-	if err != nil {
-		return fmt.Errorf("failed to load settings for static generation: %w", err)
-	}
 
 	// Generate about.html
 	aboutContent, err := loadAboutContent()
@@ -713,21 +1855,9 @@ func runGenerationLogic() error {
 
 	// Generate sitemap.xml
 	sitemapPath := filepath.Join(distDir, "sitemap.xml")
-	sitemapContent := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
-  <url>
-    <loc>%s/</loc>
-    <lastmod>%s</lastmod>
-    <changefreq>daily</changefreq>
-    <priority>1.0</priority>
-  </url>
-  <url>
-    <loc>%s/about.html</loc>
-    <lastmod>%s</lastmod>
-    <changefreq>weekly</changefreq>
-    <priority>0.8</priority>
-  </url>
-</urlset>`, settings.Domain, time.Now().Format("2006-01-02"), settings.Domain, time.Now().Format("2006-01-02"))
+	sitemapContent := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + staticSitemapEntries(settings.Domain) + recordingSitemapEntries(settings.Domain, flatMetadata) + placeSitemapEntries(settings.Domain, placeSummaries) + `
+</urlset>`
 
 	if err := os.WriteFile(sitemapPath, []byte(sitemapContent), 0644); err != nil {
 		return fmt.Errorf("failed to write sitemap.xml: %w", err)
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marker 是录音中的一个时间点或区间，时间相对源文件开头（分段录音相对第一段开头）
+type Marker struct {
+	TimeSeconds     float64 `json:"time_seconds"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"` // 区间标记的长度，0 表示时间点
+	Label           string  `json:"label"`
+	Note            string  `json:"note,omitempty"`
+}
+
+// readCueMarkers 读取 WAV 的 cue 块以及 LIST-adtl 中的 labl、note、ltxt，返回按时间排序的标记
+// 和 data 块的时长（秒）。没有 cue 块时标记为 nil
+func readCueMarkers(path string) ([]Marker, float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	chunks, err := listRiffChunks(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	read := func(chunk riffChunk) ([]byte, error) {
+		data := make([]byte, chunk.Size)
+		_, err := f.ReadAt(data, chunk.Offset)
+		return data, err
+	}
+
+	var cue, adtl []byte
+	var format wavFormat
+	var dataSize int64
+	for _, chunk := range chunks {
+		switch {
+		case chunk.ID == "cue " && cue == nil:
+			if cue, err = read(chunk); err != nil {
+				return nil, 0, fmt.Errorf("failed to read cue chunk of %s: %w", path, err)
+			}
+		case chunk.ID == "fmt ":
+			data, err := read(chunk)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to read fmt chunk of %s: %w", path, err)
+			}
+			if format, err = parseWavFormat(data); err != nil {
+				return nil, 0, err
+			}
+		case chunk.ID == "data":
+			dataSize = chunk.Size
+		case chunk.ID == "LIST" && adtl == nil:
+			data, err := read(chunk)
+			if err == nil && len(data) >= 4 && string(data[:4]) == "adtl" {
+				adtl = data
+			}
+		}
+	}
+	if format.SampleRate == 0 {
+		return nil, 0, fmt.Errorf("no fmt chunk in %s", path)
+	}
+	duration := float64(dataSize) / float64(format.BlockAlign*format.SampleRate)
+	if len(cue) < 4 {
+		return nil, duration, nil
+	}
+
+	type cuePoint struct {
+		sampleOffset uint32
+		marker       Marker
+	}
+	points := map[uint32]*cuePoint{}
+	var order []uint32
+	count := int(binary.LittleEndian.Uint32(cue[0:4]))
+	for i := 0; i < count && 4+(i+1)*24 <= len(cue); i++ {
+		p := cue[4+i*24:]
+		id := binary.LittleEndian.Uint32(p[0:4])
+		points[id] = &cuePoint{sampleOffset: binary.LittleEndian.Uint32(p[20:24])}
+		order = append(order, id)
+	}
+
+	// adtl subchunks refer back to cue points by ID
+	for offset := 4; offset+8 <= len(adtl); {
+		id := string(adtl[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(adtl[offset+4 : offset+8]))
+		end := offset + 8 + size
+		if end > len(adtl) || size < 4 {
+			break
+		}
+		data := adtl[offset+8 : end]
+		if point, ok := points[binary.LittleEndian.Uint32(data[0:4])]; ok {
+			text := func(b []byte) string { return strings.TrimSpace(strings.TrimRight(string(b), "\x00")) }
+			switch id {
+			case "labl":
+				point.marker.Label = text(data[4:])
+			case "note":
+				point.marker.Note = text(data[4:])
+			case "ltxt":
+				if len(data) >= 20 {
+					point.marker.DurationSeconds = float64(binary.LittleEndian.Uint32(data[4:8])) / float64(format.SampleRate)
+					if point.marker.Note == "" {
+						point.marker.Note = text(data[20:])
+					}
+				}
+			}
+		}
+		offset = end + size%2
+	}
+
+	markers := make([]Marker, 0, len(order))
+	for _, id := range order {
+		point := points[id]
+		m := point.marker
+		m.TimeSeconds = float64(point.sampleOffset) / float64(format.SampleRate)
+		markers = append(markers, m)
+	}
+	sortMarkers(markers)
+	for i := range markers {
+		if markers[i].Label == "" {
+			markers[i].Label = fmt.Sprintf("标记 %d", i+1)
+		}
+	}
+	return markers, duration, nil
+}
+
+// readRecorderMarkers 读取录音所有源文件中的标记；分段录音后续各段的标记按前面各段的时长顺延
+func readRecorderMarkers(meta AudioMetadata) ([]Marker, error) {
+	var markers []Marker
+	var offset float64
+	for _, path := range meta.SourcePaths() {
+		if format, _ := sourceFormatFor(path); !format.RIFF {
+			return nil, nil
+		}
+		partMarkers, duration, err := readCueMarkers(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range partMarkers {
+			m.TimeSeconds += offset
+			markers = append(markers, m)
+		}
+		offset += duration
+	}
+	return markers, nil
+}
+
+func sortMarkers(markers []Marker) {
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].TimeSeconds < markers[j].TimeSeconds })
+}
+
+// PublishedMarkers 合并录音机标记、手动标记和已接受的外部分析标记，换算为相对发布音频（裁剪后）的时间，并去掉落在裁剪范围之外的标记
+func (m AudioMetadata) PublishedMarkers() []Marker {
+	var result []Marker
+	end := m.Edit.TrimOutSeconds
+	lists := [][]Marker{m.RecorderMarkers, m.Markers}
+	for _, result := range m.acceptedExternal() {
+		lists = append(lists, result.Markers)
+	}
+	for _, list := range lists {
+		for _, marker := range list {
+			if marker.TimeSeconds < m.Edit.TrimInSeconds || (end > 0 && marker.TimeSeconds > end) {
+				continue
+			}
+			marker.TimeSeconds -= m.Edit.TrimInSeconds
+			result = append(result, marker)
+		}
+	}
+	sortMarkers(result)
+	return result
+}
+
+// parseTimecode 解析 "90"、"1:30"、"1:02:03.5" 形式的时间，返回秒数
+func parseTimecode(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, false
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, true
+}
+
+// formatTimecode 把秒数格式化为 parseTimecode 能解析的 h:mm:ss 或 m:ss，保留一位小数
+func formatTimecode(seconds float64) string {
+	tenths := int64(seconds*10 + 0.5)
+	h, m := tenths/36000, tenths/600%60
+	s := float64(tenths%600) / 10
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%04.1f", h, m, s)
+	}
+	return fmt.Sprintf("%d:%04.1f", m, s)
+}
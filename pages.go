@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordingPageData 用于向 recording.html.tmpl 传递单条录音的数据
+type RecordingPageData struct {
+	AudioMetadata
+	RootPath    string                // 从详情页回到 dist 根目录的相对路径前缀
+	Similar     []SimilarRecording    // 听起来相似的录音
+	Spectrogram *RecordingSpectrogram // 长时程假彩色频谱图，短录音没有
+}
+
+// PlacePageData 用于向 place.html.tmpl 传递单个地点的数据
+type PlacePageData struct {
+	PlaceSummary
+	RootPath string
+}
+
+// DetailPagePath 返回录音详情页相对于 dist 目录的路径
+func (m AudioMetadata) DetailPagePath() string {
+	return "recordings/" + filepath.ToSlash(sidecarBase(m.SourceFilename)) + ".html"
+}
+
+// sitePageFuncs 是静态网站页面模板共用的函数
+func sitePageFuncs() template.FuncMap {
+	return template.FuncMap{
+		"Base":           filepath.Base,
+		"formatDuration": formatDuration,
+		"timecode":       formatTimecode,
+		"add":            add,
+		"sparkline":      sparkline,
+		"indexNames":     func() []indexName { return acousticIndexNames },
+		"indexValue":     indexValue,
+		"indexSeries":    indexSeries,
+		"timeOfDayClasses": func() []struct{ Key, Label string } {
+			return timeOfDayClasses
+		},
+	}
+}
+
+// generateRecordingPages 为每条录音生成详情页，similar 是 findSimilarRecordings 的结果，
+// spectrograms 是 publishSpectrograms 的结果
+func generateRecordingPages(metas []AudioMetadata, similar map[string][]SimilarRecording, spectrograms map[string]RecordingSpectrogram) error {
+	tmpl, err := template.New("recording.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/recording.html.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to parse template recording.html.tmpl: %w", err)
+	}
+	for _, meta := range metas {
+		pagePath := filepath.Join(distDir, filepath.FromSlash(meta.DetailPagePath()))
+		if err := os.MkdirAll(filepath.Dir(pagePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", pagePath, err)
+		}
+		f, err := os.Create(pagePath)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", pagePath, err)
+		}
+		data := RecordingPageData{
+			AudioMetadata: meta,
+			RootPath:      strings.Repeat("../", strings.Count(meta.DetailPagePath(), "/")),
+			Similar:       similar[meta.SourceFilename],
+		}
+		if spectrogram, ok := spectrograms[meta.SourceFilename]; ok {
+			data.Spectrogram = &spectrogram
+		}
+		err = tmpl.Execute(f, data)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to execute template for %s: %w", pagePath, err)
+		}
+	}
+	log.Printf("Generated %d recording pages", len(metas))
+	return nil
+}
+
+// sparkline 把一组数值绘制为内联 SVG 折线图
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	span := max - min
+	if span == 0 {
+		span = 1
+	}
+	const width, height = 200.0, 40.0
+	var points []string
+	for i, v := range values {
+		x := float64(i) / float64(len(values)-1) * width
+		y := height - (v-min)/span*(height-4) - 2
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(`<svg class="sparkline" viewBox="0 0 %g %g" preserveAspectRatio="none"><polyline fill="none" stroke="currentColor" stroke-width="1.5" vector-effect="non-scaling-stroke" points="%s"/></svg>`,
+		width, height, strings.Join(points, " ")))
+}
+
+// sitemapStaticPages 是网站中固定页面的 sitemap 设置，路径相对于网站根目录
+var sitemapStaticPages = []struct{ Path, ChangeFreq, Priority string }{
+	{"", "daily", "1.0"},
+	{"about.html", "weekly", "0.8"},
+	{"stats.html", "weekly", "0.5"},
+	{"spectrograms.html", "weekly", "0.5"},
+	{"places.html", "weekly", "0.5"},
+}
+
+// staticSitemapEntries 生成固定页面的 sitemap 条目，修改日期为生成当天
+func staticSitemapEntries(domain string) string {
+	var b strings.Builder
+	today := time.Now().Format("2006-01-02")
+	for _, page := range sitemapStaticPages {
+		fmt.Fprintf(&b, "\n  <url>\n    <loc>%s</loc>\n    <lastmod>%s</lastmod>\n    <changefreq>%s</changefreq>\n    <priority>%s</priority>\n  </url>",
+			html.EscapeString(domain+"/"+page.Path), today, page.ChangeFreq, page.Priority)
+	}
+	return b.String()
+}
+
+// recordingSitemapEntries 生成各录音详情页的 sitemap 条目
+func recordingSitemapEntries(domain string, metas []AudioMetadata) string {
+	var b strings.Builder
+	for _, meta := range metas {
+		loc := domain + "/" + (&url.URL{Path: meta.DetailPagePath()}).EscapedPath()
+		fmt.Fprintf(&b, "\n  <url>\n    <loc>%s</loc>\n    <lastmod>%s</lastmod>\n    <changefreq>monthly</changefreq>\n    <priority>0.6</priority>\n  </url>",
+			html.EscapeString(loc), meta.RecordDate.Format("2006-01-02"))
+	}
+	return b.String()
+}
+
+// generateStatsPage 生成按地点汇总声级的统计页面
+func generateStatsPage(metas []AudioMetadata) error {
+	tmpl, err := template.New("stats.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/stats.html.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to parse template stats.html.tmpl: %w", err)
+	}
+	statsPath := filepath.Join(distDir, "stats.html")
+	f, err := os.Create(statsPath)
+	if err != nil {
+		return fmt.Errorf("failed to create stats.html: %w", err)
+	}
+	defer f.Close()
+	if err := tmpl.Execute(f, aggregateLevelsByLocation(metas)); err != nil {
+		return fmt.Errorf("failed to execute template for stats.html: %w", err)
+	}
+	log.Printf("Generated %s", statsPath)
+	return nil
+}
+
+// SpectrogramsPageData 用于向 spectrograms.html.tmpl 传递按文件夹和日期拼接的频谱图
+type SpectrogramsPageData struct {
+	Days  []DaySpectrogram
+	Ticks []TimeAxisTick
+}
+
+// generateSpectrogramsPage 生成按天浏览长时程频谱图的页面
+func generateSpectrogramsPage(days []DaySpectrogram) error {
+	tmpl, err := template.New("spectrograms.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/spectrograms.html.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to parse template spectrograms.html.tmpl: %w", err)
+	}
+	pagePath := filepath.Join(distDir, "spectrograms.html")
+	f, err := os.Create(pagePath)
+	if err != nil {
+		return fmt.Errorf("failed to create spectrograms.html: %w", err)
+	}
+	defer f.Close()
+	if err := tmpl.Execute(f, SpectrogramsPageData{Days: days, Ticks: dayTimeAxis()}); err != nil {
+		return fmt.Errorf("failed to execute template for spectrograms.html: %w", err)
+	}
+	log.Printf("Generated %s", pagePath)
+	return nil
+}
+
+// generatePlacePages 生成地点索引页和每个有录音的地点的页面
+func generatePlacePages(summaries map[string]*PlaceSummary, roots []PlaceSummary) error {
+	tmpl, err := template.New("place.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/place.html.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to parse template place.html.tmpl: %w", err)
+	}
+	for _, s := range summaries {
+		pagePath := filepath.Join(distDir, filepath.FromSlash(s.PagePath()))
+		if err := os.MkdirAll(filepath.Dir(pagePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", pagePath, err)
+		}
+		f, err := os.Create(pagePath)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", pagePath, err)
+		}
+		err = tmpl.Execute(f, PlacePageData{PlaceSummary: *s, RootPath: "../"})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to execute template for %s: %w", pagePath, err)
+		}
+	}
+	log.Printf("Generated %d place pages", len(summaries))
+
+	tmpl, err = template.New("places.html.tmpl").Funcs(sitePageFuncs()).ParseFS(templateFS, "templates/places.html.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to parse template places.html.tmpl: %w", err)
+	}
+	pagePath := filepath.Join(distDir, "places.html")
+	f, err := os.Create(pagePath)
+	if err != nil {
+		return fmt.Errorf("failed to create places.html: %w", err)
+	}
+	defer f.Close()
+	if err := tmpl.Execute(f, roots); err != nil {
+		return fmt.Errorf("failed to execute template for places.html: %w", err)
+	}
+	log.Printf("Generated %s", pagePath)
+	return nil
+}
+
+// placeSitemapEntries 生成各地点页面的 sitemap 条目
+func placeSitemapEntries(domain string, summaries map[string]*PlaceSummary) string {
+	ids := make([]string, 0, len(summaries))
+	for id := range summaries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		s := summaries[id]
+		loc := domain + "/" + (&url.URL{Path: s.PagePath()}).EscapedPath()
+		// Recordings are sorted newest first, so the first one dates the page
+		fmt.Fprintf(&b, "\n  <url>\n    <loc>%s</loc>\n    <lastmod>%s</lastmod>\n    <changefreq>monthly</changefreq>\n    <priority>0.5</priority>\n  </url>",
+			html.EscapeString(loc), s.Recordings[0].RecordDate.Format("2006-01-02"))
+	}
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Place 是地点登记表中的一个地点。录音通过 PlaceID 引用地点，Location 保存地点名称的副本，
+// 地点改名时由 propagatePlaceName 同步到所有引用它的录音
+type Place struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	ParentID    string           `json:"parent_id,omitempty"` // 上级区域，如保护区所在的市或省
+	Latitude    *float64         `json:"latitude,omitempty"`
+	Longitude   *float64         `json:"longitude,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Photos      []string         `json:"photos,omitempty"`  // placePhotoDir/<ID>/ 下的文件名
+	Privacy     *LocationPrivacy `json:"privacy,omitempty"` // 同时适用于下级地点
+}
+
+// PagePath 返回地点页面相对于 dist 目录的路径
+func (p Place) PagePath() string {
+	return "places/" + p.ID + ".html"
+}
+
+// PlacePagePath 返回录音所在地点页面相对于 dist 目录的路径，没有引用地点时为空
+func (m AudioMetadata) PlacePagePath() string {
+	if m.PlaceID == "" {
+		return ""
+	}
+	return Place{ID: m.PlaceID}.PagePath()
+}
+
+// placeRegistry 是 places.json 中的全部地点
+type placeRegistry []Place
+
+// placePhotoExtensions 是地点照片允许的格式
+var placePhotoExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+func loadPlaces() (placeRegistry, error) {
+	jsonContent, err := os.ReadFile(filepath.Join(jsonDir, "places.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read places.json: %w", err)
+	}
+	var places placeRegistry
+	if err := json.Unmarshal(jsonContent, &places); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal places.json: %w", err)
+	}
+	return places, nil
+}
+
+func (places placeRegistry) save() error {
+	content, err := json.MarshalIndent(places, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal places: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(jsonDir, "places.json"), content, 0644); err != nil {
+		return fmt.Errorf("failed to write places.json: %w", err)
+	}
+	return nil
+}
+
+func (places placeRegistry) byID(id string) *Place {
+	for i := range places {
+		if places[i].ID == id {
+			return &places[i]
+		}
+	}
+	return nil
+}
+
+// ancestry 返回从最上级区域到该地点本身的路径，上级缺失或成环时截断
+func (places placeRegistry) ancestry(id string) []Place {
+	var chain []Place
+	for p := places.byID(id); p != nil; p = places.byID(p.ParentID) {
+		for _, seen := range chain {
+			if seen.ID == p.ID {
+				return chain
+			}
+		}
+		chain = append([]Place{*p}, chain...)
+	}
+	return chain
+}
+
+// label 返回带上级区域的完整名称，如 "广东省 / 深圳市 / 南澳"，用于自动补全和区分同名地点
+func (places placeRegistry) label(id string) string {
+	var names []string
+	for _, p := range places.ancestry(id) {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, " / ")
+}
+
+// labels 返回所有地点的完整名称，按名称排序
+func (places placeRegistry) labels() []string {
+	labels := make([]string, 0, len(places))
+	for _, p := range places {
+		labels = append(labels, places.label(p.ID))
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (places placeRegistry) children(id string) []Place {
+	var children []Place
+	for _, p := range places {
+		if p.ParentID == id && p.ID != id {
+			children = append(children, p)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	return children
+}
+
+// isWithin 判断 id 是否为 ancestorID 本身或其下级地点
+func (places placeRegistry) isWithin(id, ancestorID string) bool {
+	for _, p := range places.ancestry(id) {
+		if p.ID == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve 把录音编辑页中输入的位置解析为地点：可以是完整名称、地点 ID 或唯一的地点名称。
+// 不匹配任何地点时作为自由文本位置保存，placeID 为空
+func (places placeRegistry) resolve(input string) (placeID, location string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", ""
+	}
+	var byName []Place
+	for _, p := range places {
+		if input == p.ID || input == places.label(p.ID) {
+			return p.ID, p.Name
+		}
+		if input == p.Name {
+			byName = append(byName, p)
+		}
+	}
+	if len(byName) == 1 {
+		return byName[0].ID, byName[0].Name
+	}
+	return "", input
+}
+
+// locationLabel 返回编辑页位置输入框中显示的值：引用地点时为其完整名称，否则为自由文本位置
+func (places placeRegistry) locationLabel(meta AudioMetadata) string {
+	if meta.PlaceID != "" && places.byID(meta.PlaceID) != nil {
+		return places.label(meta.PlaceID)
+	}
+	return meta.Location
+}
+
+// newID 返回未被使用的地点 ID。ID 不随名称改变，地点页面的网址因此保持稳定
+func (places placeRegistry) newID() string {
+	next := 1
+	for _, p := range places {
+		if n, err := strconv.Atoi(strings.TrimPrefix(p.ID, "place-")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return fmt.Sprintf("place-%d", next)
+}
+
+// applyPlaceNames 用登记表中的名称刷新录音的 Location，引用已不存在的地点时改为自由文本位置
+func applyPlaceNames(metas []AudioMetadata, places placeRegistry) {
+	for i := range metas {
+		if metas[i].PlaceID == "" {
+			continue
+		}
+		if p := places.byID(metas[i].PlaceID); p != nil {
+			metas[i].Location = p.Name
+		} else {
+			log.Printf("Warning: %s refers to unknown place %s", metas[i].SourceFilename, metas[i].PlaceID)
+			metas[i].PlaceID = ""
+		}
+	}
+}
+
+// propagatePlaceName 把地点的新名称写入所有引用它的录音
+func propagatePlaceName(place Place) error {
+	return filepath.Walk(jsonDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") || isSpecialJsonFile(info.Name()) {
+			return nil
+		}
+		metadata, err := loadAudioMetadata(path)
+		if err != nil {
+			log.Printf("Warning: could not load metadata for %s during place rename: %v", path, err)
+			return nil
+		}
+		if metadata.PlaceID != place.ID || metadata.Location == place.Name {
+			return nil
+		}
+		metadata.Location = place.Name
+		updatedJson, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal json for %s: %w", metadata.SourceFilename, err)
+		}
+		if err := os.WriteFile(path, updatedJson, 0644); err != nil {
+			return fmt.Errorf("failed to write json file %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// savePlacePhoto 把上传的照片保存到 placePhotoDir/<id>/，返回保存的文件名
+func savePlacePhoto(id string, header *multipart.FileHeader) (string, error) {
+	name := filepath.Base(header.Filename)
+	if !containsString(placePhotoExtensions, strings.ToLower(filepath.Ext(name))) {
+		return "", fmt.Errorf("unsupported photo format: %s", name)
+	}
+	in, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open uploaded photo %s: %w", name, err)
+	}
+	defer in.Close()
+	dir := filepath.Join(placePhotoDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	out, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to create photo %s: %w", name, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return "", fmt.Errorf("failed to save photo %s: %w", name, err)
+	}
+	return name, out.Close()
+}
+
+// placePhotoPath 返回地点照片的保存路径
+func placePhotoPath(id, name string) string {
+	return filepath.Join(placePhotoDir, id, filepath.Base(name))
+}
+
+// PlaceSummary 是地点页面和地点索引中的一个地点
+type PlaceSummary struct {
+	Place
+	Ancestors  []Place         // 上级区域，从最上级开始
+	Children   []PlaceSummary  // 有录音的下级地点
+	Recordings []AudioMetadata // 在该地点及其下级地点录制的录音，按时间倒序
+	PhotoPaths []string        // 照片相对于 dist 目录的路径
+}
+
+// summarizePlaces 为至少有一条已发布录音（含下级地点）的地点生成摘要，返回所有这些地点和最上级的地点
+func summarizePlaces(metas []AudioMetadata, places placeRegistry) (all map[string]*PlaceSummary, roots []PlaceSummary) {
+	all = map[string]*PlaceSummary{}
+	for _, meta := range metas {
+		if meta.PlaceID == "" {
+			continue
+		}
+		for _, p := range places.ancestry(meta.PlaceID) {
+			s, ok := all[p.ID]
+			if !ok {
+				s = &PlaceSummary{Place: p, Ancestors: places.ancestry(p.ID)}
+				s.Ancestors = s.Ancestors[:len(s.Ancestors)-1]
+				for _, photo := range p.Photos {
+					s.PhotoPaths = append(s.PhotoPaths, "assets/places/"+p.ID+"/"+photo)
+				}
+				all[p.ID] = s
+			}
+			s.Recordings = append(s.Recordings, meta)
+		}
+	}
+	// Build the children bottom-up so each summary carries its complete subtree. A hand-edited
+	// places.json may contain a cycle, so a place already on the current path is not descended into again
+	var build func(id string, path map[string]bool) PlaceSummary
+	build = func(id string, path map[string]bool) PlaceSummary {
+		s := *all[id]
+		s.Children = nil
+		path[id] = true
+		defer delete(path, id)
+		for _, child := range places.children(id) {
+			if _, ok := all[child.ID]; ok && !path[child.ID] {
+				s.Children = append(s.Children, build(child.ID, path))
+			}
+		}
+		return s
+	}
+	for id, s := range all {
+		if len(s.Ancestors) == 0 {
+			roots = append(roots, build(id, map[string]bool{}))
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Name < roots[j].Name })
+	for id := range all {
+		s := build(id, map[string]bool{})
+		all[id] = &s
+	}
+	return all, roots
+}
+
+// publishPlacePhotos 把有页面的地点的照片去掉元数据后写到 dist/assets/places
+func publishPlacePhotos(summaries map[string]*PlaceSummary) {
+	for id, s := range summaries {
+		for _, photo := range s.Photos {
+			if err := writeStrippedImage(placePhotoPath(id, photo), filepath.Join(distDir, "assets", "places", id, photo)); err != nil {
+				log.Printf("Warning: failed to publish photo %s of place %s: %v", photo, s.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	previewClipSeconds = 20.0 // 试听片段长度
+	previewFadeSeconds = 2.0  // 试听片段首尾的淡入淡出
+	loudnessSampleRate = 8000 // 响度分析时的解码采样率，只需要粗略的能量分布
+)
+
+// previewStart 返回试听片段在已发布音频中的起点：优先使用元数据中的手动设置，否则按响度自动选择
+func previewStart(meta AudioMetadata, publishedPath string, publishedDuration float64) (float64, error) {
+	if meta.PreviewStartSeconds != nil {
+		return clampPreviewStart(*meta.PreviewStartSeconds, publishedDuration), nil
+	}
+	start, err := loudestWindowStart(publishedPath, previewClipSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return clampPreviewStart(start, publishedDuration), nil
+}
+
+func clampPreviewStart(start, duration float64) float64 {
+	if duration > 0 {
+		start = math.Min(start, duration-previewClipSeconds)
+	}
+	return math.Max(start, 0)
+}
+
+// previewFingerprint 描述试听片段的生成方式；自动起点只记为 "auto"，
+// 这样在判断缓存是否有效时不必先做一遍响度分析
+func previewFingerprint(meta AudioMetadata) string {
+	startSpec := "auto"
+	if meta.PreviewStartSeconds != nil {
+		startSpec = formatSeconds(*meta.PreviewStartSeconds)
+	}
+	args := []string{"preview", startSpec, formatSeconds(previewClipSeconds), formatSeconds(previewFadeSeconds)}
+	return encoderFingerprint(append(args, aacEncoderArgs...))
+}
+
+// newPreviewEncodeJob 生成从 start 开始、带淡入淡出的试听片段转码任务
+func newPreviewEncodeJob(start, publishedDuration float64) encodeJob {
+	length := previewClipSeconds
+	if publishedDuration > 0 {
+		length = math.Min(length, publishedDuration-start)
+	}
+	fade := math.Min(previewFadeSeconds, length/2)
+	job := encodeJob{
+		InputArgs:        []string{"-ss", formatSeconds(start), "-t", formatSeconds(length)},
+		ExpectedDuration: length,
+	}
+	filter := fmt.Sprintf("afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s", formatSeconds(fade), formatSeconds(length-fade), formatSeconds(fade))
+	job.OutputArgs = append([]string{"-af", filter}, aacEncoderArgs...)
+	return job
+}
+
+// loudestWindowStart 找出平均能量最高的 window 秒窗口的起点（秒）。
+// 音频经 ffmpeg 解码为低采样率单声道 PCM 后逐秒累计能量，不会把整个文件读入内存。
+func loudestWindowStart(audioPath string, window float64) (float64, error) {
+	var energies []float64 // Sum of squares per second
+	err := decodeMonoPCM(audioPath, loudnessSampleRate, func(samples []int16) {
+		var sum float64
+		for _, v := range samples {
+			f := float64(v)
+			sum += f * f
+		}
+		energies = append(energies, sum)
+	})
+	if err != nil {
+		return 0, err
+	}
+	w := int(window)
+	if len(energies) <= w {
+		return 0, nil
+	}
+	var current float64
+	for i := 0; i < w; i++ {
+		current += energies[i]
+	}
+	best, bestStart := current, 0
+	for i := w; i < len(energies); i++ {
+		current += energies[i] - energies[i-w]
+		if current > best {
+			best, bestStart = current, i-w+1
+		}
+	}
+	return float64(bestStart), nil
+}
+
+// decodeMonoPCM 用 ffmpeg 把音频解码为单声道 16 位 PCM，并以一秒为单位回调
+func decodeMonoPCM(audioPath string, sampleRate int, fn func(samples []int16)) error {
+	cmd := exec.Command("ffmpeg", "-v", "error", "-i", audioPath, "-ac", "1", "-ar", strconv.Itoa(sampleRate), "-f", "s16le", "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open ffmpeg stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg for %s: %w", audioPath, err)
+	}
+	reader := bufio.NewReader(stdout)
+	buf := make([]byte, sampleRate*2)
+	samples := make([]int16, sampleRate)
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		count := n / 2
+		for i := 0; i < count; i++ {
+			samples[i] = int16(binary.LittleEndian.Uint16(buf[i*2:]))
+		}
+		if count > 0 {
+			fn(samples[:count])
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			cmd.Wait()
+			return fmt.Errorf("failed to read decoded audio for %s: %w", audioPath, readErr)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg decode failed for %s: %w", audioPath, err)
+	}
+	return nil
+}
+
+// buildPreviewClip 确保 previewDir 中有最新的试听片段并复制到 dist，返回相对于 dist 的路径。
+// 片段从已发布的 m4a 中截取，因此起点是相对于裁剪后音频的时间。
+func buildPreviewClip(manifest, taggedManifest *CacheManifest, meta AudioMetadata, publishedPath, relPath string, tags audioTags) (string, error) {
+	cachePath := filepath.Join(previewDir, relPath)
+	sourceHash, err := hashFile(publishedPath)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := previewFingerprint(meta)
+	_, statErr := os.Stat(cachePath)
+	if statErr != nil || !manifest.isFresh(relPath, sourceHash, fingerprint) {
+		duration := meta.PublishedDurationSeconds
+		if duration <= 0 {
+			if duration, _, _, _, err = getAudioTechInfo(publishedPath); err != nil {
+				return "", fmt.Errorf("failed to probe %s: %w", publishedPath, err)
+			}
+		}
+		start, err := previewStart(meta, publishedPath, duration)
+		if err != nil {
+			return "", fmt.Errorf("failed to choose preview start for %s: %w", meta.SourceFilename, err)
+		}
+		log.Printf("Generating preview clip for %s from %s...", meta.SourceFilename, formatDuration(start))
+		if err := transcodeToAac(publishedPath, cachePath, newPreviewEncodeJob(start, duration)); err != nil {
+			return "", err
+		}
+		manifest.record(relPath, sourceHash, fingerprint)
+		if err := manifest.save(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return publishTagged(taggedManifest, cachePath, "preview", relPath, tags)
+}
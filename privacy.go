@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// 位置隐私级别，控制生成网站时发布的位置信息。录音和地点（含其上级区域）都可以设置，取其中最严格的级别。
+// 写回源文件的 BWF 元数据属于私人存档，始终保留准确位置
+const (
+	privacyExact   = "exact"   // 发布准确坐标
+	privacyRounded = "rounded" // 坐标取到 RoundKm 公里网格的中心，去掉具体地点，只列在上级区域下
+	privacyRegion  = "region"  // 只发布上级区域名称，不发布坐标
+	privacyHidden  = "hidden"  // 不发布任何位置信息
+)
+
+// privacyLevels 是编辑页中隐私级别的顺序与名称，从宽到严
+var privacyLevels = []struct{ Key, Label string }{
+	{privacyExact, "准确位置"},
+	{privacyRounded, "模糊坐标"},
+	{privacyRegion, "仅区域"},
+	{privacyHidden, "隐藏位置"},
+}
+
+// LocationPrivacy 是录音或地点的位置隐私设置
+type LocationPrivacy struct {
+	Level   string  `json:"level"`
+	RoundKm float64 `json:"round_km,omitempty"` // rounded 级别的网格大小，默认 10 km
+}
+
+func (p *LocationPrivacy) roundKm() float64 {
+	if p.RoundKm > 0 {
+		return p.RoundKm
+	}
+	return 10
+}
+
+// privacyRank 返回级别的严格程度，未知级别与 exact 相同
+func privacyRank(level string) int {
+	for i, l := range privacyLevels {
+		if l.Key == level {
+			return i
+		}
+	}
+	return 0
+}
+
+// atLeast 判断设置是否不宽于 level，未设置时与 exact 相同
+func (p *LocationPrivacy) atLeast(level string) bool {
+	rank := 0
+	if p != nil {
+		rank = privacyRank(p.Level)
+	}
+	return rank >= privacyRank(level)
+}
+
+// stricter 返回两个设置中更严格的一个，同为 rounded 时取网格更大的一个
+func (p *LocationPrivacy) stricter(other *LocationPrivacy) *LocationPrivacy {
+	if other == nil {
+		return p
+	}
+	if p == nil || privacyRank(other.Level) > privacyRank(p.Level) ||
+		(other.Level == privacyRounded && p.Level == privacyRounded && other.roundKm() > p.roundKm()) {
+		return other
+	}
+	return p
+}
+
+// Label 返回级别的中文说明
+func (p *LocationPrivacy) Label() string {
+	if p == nil {
+		return privacyLevels[0].Label
+	}
+	for _, l := range privacyLevels {
+		if l.Key == p.Level {
+			if l.Key == privacyRounded {
+				return fmt.Sprintf("%s（%g km）", l.Label, p.roundKm())
+			}
+			return l.Label
+		}
+	}
+	return privacyLevels[0].Label
+}
+
+// effectivePrivacy 返回录音实际适用的隐私设置：录音本身、所在地点及其各级上级区域中最严格的一个。
+// regionAbove 是 region 级别时代替它发布的区域，即施加该级别的最上级地点的上级，可能为空
+func effectivePrivacy(meta AudioMetadata, places placeRegistry) (privacy *LocationPrivacy, regionAbove *Place) {
+	privacy = meta.Privacy
+	chain := places.ancestry(meta.PlaceID)
+	source := len(chain) // index of the highest place imposing region or stricter
+	if privacy.atLeast(privacyRegion) {
+		source = len(chain) - 1
+	}
+	for i, p := range chain {
+		privacy = privacy.stricter(p.Privacy)
+		if p.Privacy.atLeast(privacyRegion) && i < source {
+			source = i
+		}
+	}
+	if source > 0 && source < len(chain) {
+		return privacy, &chain[source-1]
+	}
+	return privacy, nil
+}
+
+// roundCoordinates 把坐标移到 km 公里网格的中心
+func roundCoordinates(lat, lon, km float64) (float64, float64) {
+	latStep := km / 111.32
+	lat = (math.Floor(lat/latStep) + 0.5) * latStep
+	lat = math.Max(math.Min(lat, 90), -90)
+	lonStep := math.Min(km/(111.32*math.Max(math.Cos(lat*math.Pi/180), 0.01)), 360)
+	lon = (math.Floor((lon+180)/lonStep)+0.5)*lonStep - 180
+	lon = math.Max(math.Min(lon, 180), -180)
+	return math.Round(lat*1e5) / 1e5, math.Round(lon*1e5) / 1e5
+}
+
+// publicMetadata 返回按位置隐私设置处理后可以发布的元数据副本，网页、导出文件和音频标签都应使用它
+func publicMetadata(meta AudioMetadata, places placeRegistry) AudioMetadata {
+	privacy, regionAbove := effectivePrivacy(meta, places)
+	meta.Privacy = privacy
+	if meta.Address != nil {
+		address := *meta.Address
+		meta.Address = &address
+	}
+	if !privacy.atLeast(privacyRounded) {
+		return meta
+	}
+	if !privacy.atLeast(privacyRegion) {
+		if meta.Latitude != nil && meta.Longitude != nil {
+			lat, lon := roundCoordinates(*meta.Latitude, *meta.Longitude, privacy.roundKm())
+			meta.Latitude, meta.Longitude = &lat, &lon
+			refreshSkyContext(&meta)
+		}
+		if meta.Address != nil {
+			meta.Address.Site = ""
+		}
+		// The place page shows the place's own coordinates, so list the recording there only when
+		// the place is published at least as coarsely; otherwise name the region above it
+		chain := places.ancestry(meta.PlaceID)
+		var placePrivacy *LocationPrivacy
+		for _, p := range chain {
+			placePrivacy = placePrivacy.stricter(p.Privacy)
+		}
+		if len(chain) == 0 || placePrivacy.stricter(privacy) != placePrivacy {
+			var parent *Place
+			if len(chain) > 1 {
+				parent = &chain[len(chain)-2]
+			}
+			meta.Location, meta.PlaceID = regionLocation(meta, parent)
+		}
+		return meta
+	}
+
+	meta.Latitude, meta.Longitude = nil, nil
+	if meta.Sky != nil {
+		meta.Sky = &SkyContext{
+			MoonPhase:        meta.Sky.MoonPhase,
+			MoonIllumination: meta.Sky.MoonIllumination,
+			TimeOfDay:        meta.Sky.TimeOfDay,
+			SunWithheld:      true,
+		}
+	}
+	if privacy.Level == privacyHidden {
+		meta.Location, meta.PlaceID, meta.Address = "", "", nil
+		return meta
+	}
+	// Region only: name the region above the sensitive place
+	if meta.Address != nil {
+		meta.Address.Site = ""
+	}
+	meta.Location, meta.PlaceID = regionLocation(meta, regionAbove)
+	return meta
+}
+
+// regionLocation 返回代替具体地点发布的区域：优先使用 region 地点，否则使用地址中较粗的一级
+func regionLocation(meta AudioMetadata, region *Place) (location, placeID string) {
+	switch {
+	case region != nil:
+		return region.Name, region.ID
+	case meta.Address != nil && meta.Address.District != "":
+		return meta.Address.District, ""
+	case meta.Address != nil:
+		return meta.Address.Province, ""
+	}
+	return "", ""
+}
+
+// publicPlaces 返回按各地点实际适用的隐私设置处理后的地点登记表副本
+func publicPlaces(places placeRegistry) placeRegistry {
+	public := make(placeRegistry, len(places))
+	for i, p := range places {
+		var privacy *LocationPrivacy
+		for _, a := range places.ancestry(p.ID) {
+			privacy = privacy.stricter(a.Privacy)
+		}
+		p.Privacy = privacy
+		switch {
+		case privacy.atLeast(privacyRegion):
+			p.Latitude, p.Longitude = nil, nil
+		case privacy.atLeast(privacyRounded) && p.Latitude != nil && p.Longitude != nil:
+			lat, lon := roundCoordinates(*p.Latitude, *p.Longitude, privacy.roundKm())
+			p.Latitude, p.Longitude = &lat, &lon
+		}
+		public[i] = p
+	}
+	return public
+}
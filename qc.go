@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	qcClipLevel         = 0.999 // 绝对值达到该值的样本视为满幅
+	qcClipRunSamples    = 3     // 连续满幅样本数达到该值记为一次削波
+	qcSilenceDBFS       = -90.0 // 所有通道都低于该电平的帧视为数字静音
+	qcMinSilenceSeconds = 2.0   // 静音持续该时长以上才记为静音段
+	qcMinDropoutSeconds = 0.005 // 短于静音段、但至少持续该时长的全零样本记为掉线
+	qcMaxSilentSpans    = 100   // 元数据中最多保留的静音段数量
+)
+
+// QCReport 是对源文件的质量检查结果
+type QCReport struct {
+	SourceHash          string     `json:"source_hash"` // 分析时源文件的哈希，变化后需重新分析
+	PeakDBFS            float64    `json:"peak_dbfs"`
+	RMSDBFS             float64    `json:"rms_dbfs"`
+	ClipCount           int        `json:"clip_count"`
+	DCOffset            float64    `json:"dc_offset"` // 各通道直流偏移绝对值的最大值，相对满幅
+	DropoutCount        int        `json:"dropout_count"`
+	SilentSpans         []TimeSpan `json:"silent_spans,omitempty"`
+	TotalSilenceSeconds float64    `json:"total_silence_seconds"`
+}
+
+// TimeSpan 表示录音中的一段时间，单位为秒
+type TimeSpan struct {
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds"`
+}
+
+func (t TimeSpan) Duration() float64 { return t.EndSeconds - t.StartSeconds }
+
+// maxPeakDBFS 是允许的最高峰值，峰值高于它时不通过
+func (q QCSettings) maxPeakDBFS() float64 {
+	if q.MaxPeakDBFS != nil {
+		return *q.MaxPeakDBFS
+	}
+	return -0.1
+}
+
+func (q QCSettings) maxDCOffset() float64 {
+	if q.MaxDCOffset > 0 {
+		return q.MaxDCOffset
+	}
+	return 0.01
+}
+
+func (q QCSettings) maxSilenceSeconds() float64 {
+	if q.MaxSilenceSeconds > 0 {
+		return q.MaxSilenceSeconds
+	}
+	return 5
+}
+
+// problems 返回不满足阈值的检查项说明，全部通过时返回 nil
+func (r *QCReport) problems(t QCSettings) []string {
+	if r == nil {
+		return nil
+	}
+	var problems []string
+	if r.PeakDBFS > t.maxPeakDBFS() {
+		problems = append(problems, fmt.Sprintf("峰值 %.2f dBFS", r.PeakDBFS))
+	}
+	if r.ClipCount > t.MaxClipCount {
+		problems = append(problems, fmt.Sprintf("削波 %d 处", r.ClipCount))
+	}
+	if r.DCOffset > t.maxDCOffset() {
+		problems = append(problems, fmt.Sprintf("直流偏移 %.2f%%", r.DCOffset*100))
+	}
+	if r.DropoutCount > t.MaxDropouts {
+		problems = append(problems, fmt.Sprintf("掉线 %d 处", r.DropoutCount))
+	}
+	for _, span := range r.SilentSpans {
+		if span.Duration() > t.maxSilenceSeconds() {
+			problems = append(problems, fmt.Sprintf("数字静音 %s 起 %.1f 秒", formatDuration(span.StartSeconds), span.Duration()))
+			break
+		}
+	}
+	return problems
+}
+
+func toDBFS(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(amplitude)
+}
+
+// qcAnalyzer 逐块统计峰值、RMS、削波、直流偏移、掉线和数字静音
+type qcAnalyzer struct {
+	peak, sumSquares float64
+	sampleCount      int64
+	channelSums      []float64
+	clipRuns         []int
+	clipCount        int
+	frame            int64
+	silentStart      int64
+	zeroStart        int64
+	sampleRate       int
+	silenceLevel     float64
+	report           *QCReport
+}
+
+func newQCAnalyzer() *qcAnalyzer {
+	return &qcAnalyzer{
+		silentStart:  -1,
+		zeroStart:    -1,
+		silenceLevel: math.Pow(10, qcSilenceDBFS/20),
+		report:       &QCReport{},
+	}
+}
+
+func (a *qcAnalyzer) endSilence(end int64) {
+	if a.silentStart < 0 {
+		return
+	}
+	span := TimeSpan{StartSeconds: float64(a.silentStart) / float64(a.sampleRate), EndSeconds: float64(end) / float64(a.sampleRate)}
+	if span.Duration() >= qcMinSilenceSeconds {
+		a.report.TotalSilenceSeconds += span.Duration()
+		if len(a.report.SilentSpans) < qcMaxSilentSpans {
+			a.report.SilentSpans = append(a.report.SilentSpans, span)
+		}
+	}
+	a.silentStart = -1
+}
+
+func (a *qcAnalyzer) endZeros(end int64) {
+	if a.zeroStart < 0 {
+		return
+	}
+	length := float64(end-a.zeroStart) / float64(a.sampleRate)
+	if length >= qcMinDropoutSeconds && length < qcMinSilenceSeconds {
+		a.report.DropoutCount++
+	}
+	a.zeroStart = -1
+}
+
+func (a *qcAnalyzer) add(samples []float64, channels, sampleRate int) {
+	a.sampleRate = sampleRate
+	if a.channelSums == nil {
+		a.channelSums = make([]float64, channels)
+		a.clipRuns = make([]int, channels)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		silent, zero := true, true
+		for c := 0; c < channels; c++ {
+			v := samples[i+c]
+			abs := math.Abs(v)
+			a.peak = math.Max(a.peak, abs)
+			a.sumSquares += v * v
+			a.channelSums[c] += v
+			if abs >= qcClipLevel {
+				a.clipRuns[c]++
+				if a.clipRuns[c] == qcClipRunSamples {
+					a.clipCount++
+				}
+			} else {
+				a.clipRuns[c] = 0
+			}
+			if abs >= a.silenceLevel {
+				silent = false
+			}
+			if v != 0 {
+				zero = false
+			}
+		}
+		a.sampleCount += int64(channels)
+		if silent && a.silentStart < 0 {
+			a.silentStart = a.frame
+		} else if !silent {
+			a.endSilence(a.frame)
+		}
+		if zero && a.zeroStart < 0 {
+			a.zeroStart = a.frame
+		} else if !zero {
+			a.endZeros(a.frame)
+		}
+		a.frame++
+	}
+}
+
+func (a *qcAnalyzer) finish() (*QCReport, error) {
+	if a.sampleCount == 0 {
+		return nil, fmt.Errorf("no audio samples decoded")
+	}
+	a.endSilence(a.frame)
+	a.endZeros(a.frame)
+
+	report := a.report
+	// JSON can't hold -Inf, an all-zero file is reported at the 24-bit floor instead
+	report.PeakDBFS = math.Max(toDBFS(a.peak), -144)
+	report.RMSDBFS = math.Max(toDBFS(math.Sqrt(a.sumSquares/float64(a.sampleCount))), -144)
+	report.ClipCount = a.clipCount
+	frames := float64(a.sampleCount) / float64(len(a.channelSums))
+	for _, sum := range a.channelSums {
+		report.DCOffset = math.Max(report.DCOffset, math.Abs(sum/frames))
+	}
+	return report, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceFormat 描述一种可导入的源文件格式，解码统一交给 openAudioStream 和 ffmpeg
+type sourceFormat struct {
+	Name       string
+	Extensions []string // 小写扩展名
+	RIFF       bool     // RIFF/RF64 WAV：可以直接读取 bext、iXML 等块，也可以写回元数据
+	Lossy      bool     // 有损格式，发布时会再经过一次有损编码
+}
+
+var sourceFormats = []sourceFormat{
+	{Name: "WAV", Extensions: []string{".wav", ".bwf", ".rf64"}, RIFF: true},
+	{Name: "FLAC", Extensions: []string{".flac"}},
+	{Name: "AIFF", Extensions: []string{".aif", ".aiff", ".aifc"}},
+	{Name: "MP3", Extensions: []string{".mp3"}, Lossy: true},
+	{Name: "M4A", Extensions: []string{".m4a", ".mp4", ".aac"}, Lossy: true},
+	{Name: "Ogg", Extensions: []string{".ogg", ".opus"}, Lossy: true},
+}
+
+// sourceFormatFor 按扩展名（不区分大小写）返回文件的源格式
+func sourceFormatFor(filename string) (sourceFormat, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, format := range sourceFormats {
+		for _, e := range format.Extensions {
+			if e == ext {
+				return format, true
+			}
+		}
+	}
+	return sourceFormat{}, false
+}
+
+func isSourceAudio(filename string) bool {
+	_, ok := sourceFormatFor(filename)
+	return ok
+}
+
+// sidecarBase 返回去掉扩展名的相对路径。JSON、m4a 缓存、试听片段和 HLS 都以它命名，
+// 因此同一目录中只能有一个同名的源文件
+func sidecarBase(relPath string) string {
+	return strings.TrimSuffix(relPath, filepath.Ext(relPath))
+}
+
+// findSourceFiles 返回 wav 目录中去掉扩展名后与 base 相同（不区分大小写）的源文件相对路径
+func findSourceFiles(base string) []string {
+	entries, err := os.ReadDir(filepath.Join(wavDir, filepath.Dir(base)))
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, entry := range entries {
+		rel := filepath.Join(filepath.Dir(base), entry.Name())
+		if !entry.IsDir() && isSourceAudio(entry.Name()) && strings.EqualFold(sidecarBase(rel), base) {
+			found = append(found, rel)
+		}
+	}
+	return found
+}
+
+// sameFile 判断两个路径是否指向同一个文件
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	spectrogramFingerprint   = "ldfc-aci-ent-cvr-v1" // 图像定义变化时修改，缓存会被重新生成
+	spectrogramBinHz         = 43.0                  // 目标频率分辨率，FFT 长度取最接近的 2 的幂
+	spectrogramRows          = 256                   // 图像高度，约覆盖 0-11 kHz
+	spectrogramMinuteSeconds = 60.0
+	spectrogramHistMinDB     = -140.0 // 估计背景电平（众数）的直方图范围，精度 1 dB
+	spectrogramHistBins      = 140
+	spectrogramCoverDB       = 3.0 // 高出背景该值的帧计入覆盖率
+)
+
+// spectrogramIndexRange 是三个指数映射到 0-255 时使用的固定范围，固定范围使不同录音和不同日期的图像可以直接比较和拼接
+var spectrogramIndexRange = struct{ ACI, ENT, CVR [2]float64 }{
+	ACI: [2]float64{0.4, 0.8},
+	ENT: [2]float64{0, 0.5},
+	CVR: [2]float64{0.15, 0.5}, // 稳态噪声本身约有 0.15 的帧高于背景 3 dB
+}
+
+// SpectrogramSettings 控制长时程假彩色频谱图
+type SpectrogramSettings struct {
+	Disabled           bool    `json:"disabled"`
+	MinDurationSeconds float64 `json:"min_duration_seconds"` // 只为不短于该时长的录音生成，默认 10 分钟
+}
+
+func (s SpectrogramSettings) minDurationSeconds() float64 {
+	if s.MinDurationSeconds > 0 {
+		return s.MinDurationSeconds
+	}
+	return 600
+}
+
+// spectrogramAnalyzer 计算长时程假彩色频谱图：每分钟一列，每个频点一行，
+// 红色为声学复杂度 ACI，绿色为 1 - 时间熵，蓝色为高于背景电平的帧所占比例
+type spectrogramAnalyzer struct {
+	sampleRate int
+	fftSize    int
+	window     []float64
+	frame      []float64
+	filled     int
+	buf        []complex128
+	rows       int
+	// Per-minute accumulators for every row
+	frames    int
+	prev      []float64
+	sumAmp    []float64
+	sumDiff   []float64
+	sumEnergy []float64
+	sumELogE  []float64
+	hist      [][]int32
+	columns   [][3][]float64
+}
+
+func newSpectrogramAnalyzer() *spectrogramAnalyzer {
+	return &spectrogramAnalyzer{}
+}
+
+func (a *spectrogramAnalyzer) init(sampleRate int) {
+	a.sampleRate = sampleRate
+	a.fftSize = 256
+	for float64(sampleRate)/float64(a.fftSize) > spectrogramBinHz*1.5 {
+		a.fftSize *= 2
+	}
+	a.window = make([]float64, a.fftSize)
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(a.fftSize-1))
+	}
+	a.frame = make([]float64, a.fftSize)
+	a.buf = make([]complex128, a.fftSize)
+	a.rows = min(spectrogramRows, a.fftSize/2)
+	a.prev = make([]float64, a.rows)
+	a.resetMinute()
+}
+
+func (a *spectrogramAnalyzer) resetMinute() {
+	a.frames = 0
+	a.sumAmp = make([]float64, a.rows)
+	a.sumDiff = make([]float64, a.rows)
+	a.sumEnergy = make([]float64, a.rows)
+	a.sumELogE = make([]float64, a.rows)
+	a.hist = make([][]int32, a.rows)
+	for r := range a.hist {
+		a.hist[r] = make([]int32, spectrogramHistBins)
+	}
+}
+
+func (a *spectrogramAnalyzer) framesPerMinute() int {
+	return int(spectrogramMinuteSeconds * float64(a.sampleRate) / float64(a.fftSize))
+}
+
+func (a *spectrogramAnalyzer) add(samples []float64, channels, sampleRate int) {
+	if a.sampleRate == 0 {
+		a.init(sampleRate)
+	}
+	for i := 0; i+channels <= len(samples); i += channels {
+		var mono float64
+		for c := 0; c < channels; c++ {
+			mono += samples[i+c]
+		}
+		a.frame[a.filled] = mono / float64(channels)
+		a.filled++
+		if a.filled == a.fftSize {
+			a.processFrame()
+			a.filled = 0
+		}
+	}
+}
+
+func (a *spectrogramAnalyzer) processFrame() {
+	for i, x := range a.frame {
+		a.buf[i] = complex(x*a.window[i], 0)
+	}
+	fft(a.buf)
+	scale := 4 / float64(a.fftSize)
+	for r := 0; r < a.rows; r++ {
+		amp := cmplx.Abs(a.buf[r]) * scale
+		energy := amp * amp
+		a.sumAmp[r] += amp
+		if a.frames > 0 {
+			a.sumDiff[r] += math.Abs(amp - a.prev[r])
+		}
+		a.prev[r] = amp
+		a.sumEnergy[r] += energy
+		if energy > 0 {
+			a.sumELogE[r] += energy * math.Log(energy)
+		}
+		bin := int(10*math.Log10(energy+1e-30) - spectrogramHistMinDB)
+		a.hist[r][min(max(bin, 0), spectrogramHistBins-1)]++
+	}
+	a.frames++
+	if a.frames >= a.framesPerMinute() {
+		a.flushMinute()
+	}
+}
+
+func (a *spectrogramAnalyzer) flushMinute() {
+	if a.frames < 2 {
+		return
+	}
+	var column [3][]float64
+	for i := range column {
+		column[i] = make([]float64, a.rows)
+	}
+	n := float64(a.frames)
+	for r := 0; r < a.rows; r++ {
+		if a.sumAmp[r] > 0 {
+			column[0][r] = a.sumDiff[r] / a.sumAmp[r]
+		}
+		// Temporal entropy of the energy envelope: H = ln E - Σ e ln e / E, normalized by ln N
+		if e := a.sumEnergy[r]; e > 0 {
+			h := (math.Log(e) - a.sumELogE[r]/e) / math.Log(n)
+			column[1][r] = 1 - math.Min(math.Max(h, 0), 1)
+		}
+		// Background is the most common frame level at or below the median, so that
+		// a sound present in a large minority of frames does not become the background
+		var count int32
+		median := spectrogramHistBins - 1
+		for bin, c := range a.hist[r] {
+			if count += c; float64(count) >= n/2 {
+				median = bin
+				break
+			}
+		}
+		background, best := 0, int32(-1)
+		for bin := 0; bin <= median; bin++ {
+			// Smooth over ±2 dB so sampling noise in the histogram does not move the mode
+			var c int32
+			for j := max(bin-2, 0); j <= min(bin+2, spectrogramHistBins-1); j++ {
+				c += a.hist[r][j]
+			}
+			if c > best {
+				background, best = bin, c
+			}
+		}
+		threshold := background + int(math.Ceil(spectrogramCoverDB))
+		var above int32
+		for bin := threshold + 1; bin < spectrogramHistBins; bin++ {
+			above += a.hist[r][bin]
+		}
+		column[2][r] = float64(above) / n
+	}
+	a.columns = append(a.columns, column)
+	a.resetMinute()
+}
+
+func (a *spectrogramAnalyzer) finish() (image.Image, error) {
+	// Keep a trailing partial minute if it covers at least a quarter of a minute
+	if a.sampleRate > 0 && a.frames*4 >= a.framesPerMinute() {
+		a.flushMinute()
+	}
+	if len(a.columns) == 0 {
+		return nil, fmt.Errorf("recording is shorter than one spectrogram column")
+	}
+	scale := func(v float64, bounds [2]float64) uint8 {
+		return uint8(math.Round(255 * math.Min(math.Max((v-bounds[0])/(bounds[1]-bounds[0]), 0), 1)))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(a.columns), spectrogramRows))
+	for x, column := range a.columns {
+		for r := 0; r < a.rows; r++ {
+			// Low frequencies at the bottom
+			img.SetNRGBA(x, spectrogramRows-1-r, color.NRGBA{
+				R: scale(column[0][r], spectrogramIndexRange.ACI),
+				G: scale(column[1][r], spectrogramIndexRange.ENT),
+				B: scale(column[2][r], spectrogramIndexRange.CVR),
+				A: 255,
+			})
+		}
+		for r := a.rows; r < spectrogramRows; r++ {
+			img.SetNRGBA(x, spectrogramRows-1-r, color.NRGBA{A: 255})
+		}
+	}
+	return img, nil
+}
+
+// spectrogramCachePath 返回录音的频谱图在 spectrogramDir 中的相对路径
+func spectrogramCachePath(sourceFilename string) string {
+	return sidecarBase(sourceFilename) + ".png"
+}
+
+// writeSpectrogramCache 把频谱图写入缓存目录并记录到清单
+func writeSpectrogramCache(relPath, sourceHash string, img image.Image) error {
+	path := filepath.Join(spectrogramDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writePNG(path, img); err != nil {
+		return err
+	}
+	return updateCacheManifest(spectrogramDir, func(m *CacheManifest) {
+		m.record(relPath, sourceHash, spectrogramFingerprint)
+	})
+}
+
+func writePNG(path string, img image.Image) error {
+	tmpPath := path + transcodeTempSuffix
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// TimeAxisTick 是频谱图下方时间轴上的一个刻度
+type TimeAxisTick struct {
+	Percent float64 // 距图像左边缘的比例 (0-100)
+	Label   string
+}
+
+// recordingTimeAxis 为从 start 开始、共 minutes 列的频谱图生成整点刻度，时间为录音的本地时间
+func recordingTimeAxis(start time.Time, minutes int) []TimeAxisTick {
+	total := time.Duration(minutes) * time.Minute
+	step := time.Hour
+	for total/step > 12 {
+		step += time.Hour
+	}
+	var ticks []TimeAxisTick
+	for t := start.Truncate(time.Hour).Add(time.Hour); t.Sub(start) < total; t = t.Add(step) {
+		ticks = append(ticks, TimeAxisTick{
+			Percent: float64(t.Sub(start)) / float64(total) * 100,
+			Label:   t.Format("15:04"),
+		})
+	}
+	return ticks
+}
+
+// dayTimeAxis 是一整天频谱图的刻度，每 3 小时一个
+func dayTimeAxis() []TimeAxisTick {
+	var ticks []TimeAxisTick
+	for h := 0; h <= 24; h += 3 {
+		ticks = append(ticks, TimeAxisTick{Percent: float64(h) / 24 * 100, Label: fmt.Sprintf("%02d:00", h)})
+	}
+	return ticks
+}
+
+// RecordingSpectrogram 是详情页上的频谱图
+type RecordingSpectrogram struct {
+	Path      string // 相对于 dist 目录
+	Ticks     []TimeAxisTick
+	DayAnchor string // 录音开始当天的拼接图在 spectrograms.html 中的锚点，不参与拼接时为空
+}
+
+// DaySpectrogram 是一个文件夹中一天（录音本地日期）的拼接频谱图
+type DaySpectrogram struct {
+	Folder     string
+	Date       string
+	Anchor     string
+	Path       string // 相对于 dist 目录
+	Recordings []AudioMetadata
+}
+
+// daySpectrogramName 是拼接图的文件名（不含扩展名），也用作页面锚点。
+// 文件夹以路径的哈希区分，避免 a/b 与 a_b 这类名称互相覆盖
+func daySpectrogramName(folder, date string) string {
+	if folder == "." {
+		return date
+	}
+	sum := sha256.Sum256([]byte(folder))
+	return date + "_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// trimSpectrogram 按剪辑的入点和出点裁掉频谱图中被剪掉的分钟，只保留完全落在发布范围内的列。
+// 返回裁剪后的图像和其第一列距源文件开头的分钟数，没有剩余的列时返回 nil
+func trimSpectrogram(img image.Image, edit EditPoints) (image.Image, int) {
+	bounds := img.Bounds()
+	first := int(math.Ceil(edit.TrimInSeconds / spectrogramMinuteSeconds))
+	last := bounds.Dx()
+	if edit.TrimOutSeconds > 0 {
+		last = min(last, int(math.Floor(edit.TrimOutSeconds/spectrogramMinuteSeconds)))
+	}
+	if first >= last {
+		return nil, 0
+	}
+	if first == 0 && last == bounds.Dx() {
+		return img, 0
+	}
+	trimmed := image.NewNRGBA(image.Rect(0, 0, last-first, bounds.Dy()))
+	draw.Draw(trimmed, trimmed.Bounds(), img, bounds.Min.Add(image.Pt(first, 0)), draw.Src)
+	return trimmed, first
+}
+
+// publishSpectrograms 把缓存中有效的频谱图复制到 dist，并按文件夹和本地日期拼接成 24 小时的图像。
+// metas 应是 publicMetadata 处理后的元数据。返回以源文件名为键的单条录音频谱图，以及各天的拼接图
+func publishSpectrograms(metas []AudioMetadata) (map[string]RecordingSpectrogram, []DaySpectrogram, error) {
+	manifest, err := loadCacheManifest(spectrogramDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordings := map[string]RecordingSpectrogram{}
+	type dayKey struct{ folder, date string }
+	days := map[dayKey]*image.NRGBA{}
+	dayRecordings := map[dayKey][]AudioMetadata{}
+	for _, meta := range metas {
+		relPath := spectrogramCachePath(meta.SourceFilename)
+		if !manifest.isFresh(relPath, meta.SourceHash, spectrogramFingerprint) {
+			continue
+		}
+		img, err := readPNG(filepath.Join(spectrogramDir, relPath))
+		if err != nil {
+			log.Printf("Warning: Failed to read spectrogram of %s: %v", meta.SourceFilename, err)
+			continue
+		}
+		// The cache covers the whole source; only the minutes that survive the edit are published
+		sourceMinutes := img.Bounds().Dx()
+		img, first := trimSpectrogram(img, meta.Edit.normalize())
+		if img == nil {
+			continue
+		}
+		minutes := img.Bounds().Dx()
+		start := meta.RecordDate.Add(time.Duration(first) * time.Minute)
+		distRelPath := filepath.ToSlash(filepath.Join("assets", "spectrograms", relPath))
+		distPath := filepath.Join(distDir, distRelPath)
+		if minutes == sourceMinutes {
+			err = copyFile(filepath.Join(spectrogramDir, relPath), distPath)
+		} else if err = os.MkdirAll(filepath.Dir(distPath), 0755); err == nil {
+			err = writePNG(distPath, img)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to publish spectrogram of %s: %v", meta.SourceFilename, err)
+			continue
+		}
+		// Folder names usually say where a recording was made, so recordings whose location is withheld
+		// keep their own spectrogram but stay out of the per-folder day images
+		if meta.Privacy.atLeast(privacyRegion) {
+			recordings[meta.SourceFilename] = RecordingSpectrogram{Path: distRelPath, Ticks: recordingTimeAxis(start, minutes)}
+			continue
+		}
+		folder := filepath.ToSlash(filepath.Dir(meta.SourceFilename))
+		recordings[meta.SourceFilename] = RecordingSpectrogram{
+			Path:      distRelPath,
+			Ticks:     recordingTimeAxis(start, minutes),
+			DayAnchor: daySpectrogramName(folder, start.Format("2006-01-02")),
+		}
+
+		// Each column lands on its minute of the local day; long recordings spill into the following days
+		for x := 0; x < minutes; x++ {
+			t := start.Add(time.Duration(x) * time.Minute)
+			key := dayKey{folder, t.Format("2006-01-02")}
+			day, ok := days[key]
+			if !ok {
+				day = image.NewNRGBA(image.Rect(0, 0, 24*60, spectrogramRows))
+				for i := 3; i < len(day.Pix); i += 4 {
+					day.Pix[i] = 255
+				}
+				days[key] = day
+			}
+			if n := len(dayRecordings[key]); n == 0 || dayRecordings[key][n-1].SourceFilename != meta.SourceFilename {
+				dayRecordings[key] = append(dayRecordings[key], meta)
+			}
+			column := t.Hour()*60 + t.Minute()
+			for y := 0; y < spectrogramRows; y++ {
+				day.Set(column, y, img.At(x, y))
+			}
+		}
+	}
+
+	var daySpectrograms []DaySpectrogram
+	for key, img := range days {
+		name := daySpectrogramName(key.folder, key.date)
+		distRelPath := filepath.ToSlash(filepath.Join("assets", "spectrograms", "days", name+".png"))
+		path := filepath.Join(distDir, distRelPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, nil, err
+		}
+		if err := writePNG(path, img); err != nil {
+			return nil, nil, err
+		}
+		daySpectrograms = append(daySpectrograms, DaySpectrogram{Folder: key.folder, Date: key.date, Anchor: name, Path: distRelPath, Recordings: dayRecordings[key]})
+	}
+	sort.Slice(daySpectrograms, func(i, j int) bool {
+		if daySpectrograms[i].Folder != daySpectrograms[j].Folder {
+			return daySpectrograms[i].Folder < daySpectrograms[j].Folder
+		}
+		return daySpectrograms[i].Date < daySpectrograms[j].Date
+	})
+	return recordings, daySpectrograms, nil
+}
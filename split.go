@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitSuffixPattern 匹配录音机分割长录音时加在文件名后的序号，例如 ZOOM0001_0001、TASCAM_0003-0002
+var splitSuffixPattern = regexp.MustCompile(`^(.+)[_-](\d{3,4})$`)
+
+// splitContinuityTolerance 是相邻分段时间衔接允许的误差，FAT 文件系统的时间精度为 2 秒
+const splitContinuityTolerance = 3 * time.Second
+
+// splitLengthTolerance 是各分段时长允许的差异（秒）。录音机按固定的文件大小或时长分割，
+// 除最后一段外每段一样长
+const splitLengthTolerance = 1.0
+
+// concatDemuxerArgs 让 ffmpeg 把输入当作分段列表，按顺序无缝拼接
+var concatDemuxerArgs = []string{"-f", "concat", "-safe", "0"}
+
+// SourcePaths 返回录音所有源文件的绝对路径，分段录音按顺序返回每一段
+func (m AudioMetadata) SourcePaths() []string {
+	if len(m.SplitParts) > 1 {
+		paths := make([]string, len(m.SplitParts))
+		for i, part := range m.SplitParts {
+			paths[i] = filepath.Join(wavDir, part)
+		}
+		return paths
+	}
+	return []string{filepath.Join(wavDir, m.SourceFilename)}
+}
+
+// splitPartInfo 是判断两个文件能否拼接所需的信息
+type splitPartInfo struct {
+	SampleRate, Channels, BitDepth int
+	Duration                       float64
+	Recorder                       string    // bext 中的 Originator，没有时为空
+	BextStart                      time.Time // bext 中的录音起始时间，没有时为零值
+	BirthTime, ModTime             time.Time
+}
+
+func probeSplitPart(relPath string) (splitPartInfo, error) {
+	path := filepath.Join(wavDir, relPath)
+	info, err := os.Stat(path)
+	if err != nil {
+		return splitPartInfo{}, err
+	}
+	part := splitPartInfo{BirthTime: GetBirthTime(info), ModTime: info.ModTime()}
+	if part.Duration, part.SampleRate, part.BitDepth, part.Channels, err = getAudioTechInfo(path); err != nil {
+		return splitPartInfo{}, err
+	}
+	if format, _ := sourceFormatFor(relPath); format.RIFF {
+		if bext, err := readBext(path); err == nil && bext != nil {
+			// Only differences between parts matter, so the time zone is irrelevant
+			part.BextStart, _ = time.Parse("2006-01-02 15:04:05", bext.OriginationDate+" "+bext.OriginationTime)
+			part.Recorder = bext.Originator
+		}
+	}
+	return part, nil
+}
+
+// continuedBy 判断 next 是否紧接在 p 之后录制：录音机和格式必须相同，并且按 bext 起始时间、
+// 文件创建时间或文件修改时间（录音机在关闭文件时写入）之一首尾相接
+func (p splitPartInfo) continuedBy(next splitPartInfo) bool {
+	if p.SampleRate != next.SampleRate || p.Channels != next.Channels || p.BitDepth != next.BitDepth || p.Recorder != next.Recorder {
+		return false
+	}
+	duration := time.Duration(p.Duration * float64(time.Second))
+	near := func(a, b time.Time) bool {
+		return math.Abs(float64(a.Sub(b))) <= float64(splitContinuityTolerance)
+	}
+	if !p.BextStart.IsZero() && !next.BextStart.IsZero() {
+		return near(p.BextStart.Add(duration), next.BextStart)
+	}
+	nextDuration := time.Duration(next.Duration * float64(time.Second))
+	return near(p.BirthTime.Add(duration), next.BirthTime) || near(p.ModTime, next.ModTime.Add(-nextDuration))
+}
+
+// detectSplitSets 在源文件中找出录音机分割的分段，两个结果都以第一段为键、按顺序排列各段相对路径。
+// confirmed 是已在管理界面确认、记录在 JSON 中且与现有文件一致的分组，直接沿用，不再探测；
+// proposed 是新发现的分组：序号连续、录音机和格式相同、时间首尾相接，并且除最后一段外时长相同。
+// 新分组只作为建议显示在编辑页，确认后才会合并，避免把两次独立的录音拼在一起
+func detectSplitSets(relPaths []string) (confirmed, proposed map[string][]string) {
+	type candidate struct {
+		relPath string
+		index   int
+	}
+	exists := make(map[string]bool, len(relPaths))
+	groups := map[string][]candidate{}
+	for _, relPath := range relPaths {
+		exists[relPath] = true
+		m := splitSuffixPattern.FindStringSubmatch(sidecarBase(filepath.Base(relPath)))
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[2])
+		key := filepath.Join(filepath.Dir(relPath), m[1]) + "\x00" + strings.ToLower(filepath.Ext(relPath))
+		groups[key] = append(groups[key], candidate{relPath, index})
+	}
+
+	confirmed, proposed = map[string][]string{}, map[string][]string{}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].index < group[j].index })
+
+		if known, err := getMetadataBySourceFilename(group[0].relPath); err == nil && len(known.SplitParts) == len(group) {
+			complete := true
+			for _, part := range known.SplitParts {
+				complete = complete && exists[part]
+			}
+			if complete {
+				confirmed[known.SplitParts[0]] = known.SplitParts
+				continue
+			}
+		}
+
+		var run []string
+		var first, previous *splitPartInfo
+		flush := func() {
+			if len(run) > 1 {
+				proposed[run[0]] = run
+			}
+			run, first, previous = nil, nil, nil
+		}
+		for i, c := range group {
+			if i > 0 && c.index != group[i-1].index+1 {
+				flush()
+			}
+			part, err := probeSplitPart(c.relPath)
+			if err != nil {
+				log.Printf("Warning: Failed to probe %s for split detection: %v", c.relPath, err)
+				flush()
+				continue
+			}
+			// The previous part is now an inner one, so it must be exactly as long as the first
+			if previous != nil && (!previous.continuedBy(part) ||
+				math.Abs(previous.Duration-first.Duration) > splitLengthTolerance ||
+				part.Duration > first.Duration+splitLengthTolerance) {
+				flush()
+			}
+			run = append(run, c.relPath)
+			if first == nil {
+				first = &part
+			}
+			previous = &part
+		}
+		flush()
+	}
+	return confirmed, proposed
+}
+
+// splitSetTotals 返回各分段的总时长和总大小 (MB)
+func splitSetTotals(parts []string) (duration, sizeMB float64, err error) {
+	for _, part := range parts {
+		path := filepath.Join(wavDir, part)
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		d, _, _, _, err := getAudioTechInfo(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		duration += d
+		sizeMB += float64(info.Size()) / (1024 * 1024)
+	}
+	return duration, sizeMB, nil
+}
+
+// writeConcatList 为 ffmpeg concat 分离器写入分段列表，返回列表文件路径，用完后由调用方删除
+func writeConcatList(paths []string) (string, error) {
+	f, err := os.CreateTemp("", "earth-waves-concat-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create concat list: %w", err)
+	}
+	defer f.Close()
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+		if _, err := fmt.Fprintf(f, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`)); err != nil {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("failed to write concat list: %w", err)
+		}
+	}
+	return f.Name(), nil
+}
+
+// concatReaderAt 把多个片段按顺序拼成一个连续的 io.ReaderAt
+type concatReaderAt []*io.SectionReader
+
+func (c concatReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, section := range c {
+		if off >= section.Size() {
+			off -= section.Size()
+			continue
+		}
+		m, err := section.ReadAt(p[n:], off)
+		n += m
+		if n == len(p) {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		off = 0
+	}
+	return n, io.EOF
+}
+
+// openSplitWAV 把各段 WAV 的 PCM 数据接在一个新的文件头之后，得到一个可以随机读取的完整 WAV，
+// 不需要临时文件。各段的 fmt 块必须完全相同。返回的 closeParts 关闭所有分段文件
+func openSplitWAV(paths []string) (audio io.ReadSeeker, closeParts func(), err error) {
+	var files []*os.File
+	closeParts = func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	var format []byte
+	sections := concatReaderAt{nil} // The header goes first once the data size is known
+	var dataSize int64
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeParts()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		chunks, err := listRiffChunks(f)
+		if err != nil {
+			closeParts()
+			return nil, nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		var fmtChunk, dataChunk *riffChunk
+		for i := range chunks {
+			switch chunks[i].ID {
+			case "fmt ":
+				fmtChunk = &chunks[i]
+			case "data":
+				dataChunk = &chunks[i]
+			}
+		}
+		if fmtChunk == nil || dataChunk == nil || fmtChunk.Size > 1024 {
+			closeParts()
+			return nil, nil, fmt.Errorf("%s has no usable fmt and data chunks", path)
+		}
+		partFormat := make([]byte, fmtChunk.Size)
+		if _, err := f.ReadAt(partFormat, fmtChunk.Offset); err != nil {
+			closeParts()
+			return nil, nil, fmt.Errorf("failed to read fmt chunk of %s: %w", path, err)
+		}
+		if format == nil {
+			format = partFormat
+		} else if !bytes.Equal(format, partFormat) {
+			closeParts()
+			return nil, nil, fmt.Errorf("%s has a different sample format", path)
+		}
+		// A part cut short by a flat battery declares more data than it holds
+		info, err := f.Stat()
+		if err != nil {
+			closeParts()
+			return nil, nil, err
+		}
+		size := min(dataChunk.Size, info.Size()-dataChunk.Offset)
+		sections = append(sections, io.NewSectionReader(f, dataChunk.Offset, size))
+		dataSize += size
+	}
+
+	// Sizes beyond 4 GiB don't fit a plain RIFF header, players then read up to the end of the stream
+	clamp := func(n int64) uint32 { return uint32(min(n, math.MaxUint32)) }
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, clamp(4+8+int64(len(format))+int64(len(format)%2)+8+dataSize))
+	header.WriteString("WAVEfmt ")
+	binary.Write(&header, binary.LittleEndian, uint32(len(format)))
+	header.Write(format)
+	if len(format)%2 == 1 {
+		header.WriteByte(0)
+	}
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, clamp(dataSize))
+	sections[0] = io.NewSectionReader(bytes.NewReader(header.Bytes()), 0, int64(header.Len()))
+	return io.NewSectionReader(sections, 0, int64(header.Len())+dataSize), closeParts, nil
+}
+
+// serveSplitAudio 把分段录音作为一个完整的文件发送给编辑页试听。WAV 分段直接拼接，支持拖动进度；
+// 其它格式不能按字节拼接，由 ffmpeg 实时转为 FLAC 流，不能拖动
+func serveSplitAudio(w http.ResponseWriter, r *http.Request, meta AudioMetadata) error {
+	paths := meta.SourcePaths()
+	if format, _ := sourceFormatFor(meta.SourceFilename); format.RIFF {
+		audio, closeParts, err := openSplitWAV(paths)
+		if err == nil {
+			defer closeParts()
+			w.Header().Set("Content-Type", "audio/wav")
+			http.ServeContent(w, r, filepath.Base(meta.SourceFilename), time.Time{}, audio)
+			return nil
+		}
+		log.Printf("Warning: %v. Joining %s with ffmpeg instead.", err, meta.SourceFilename)
+	}
+	listPath, err := writeConcatList(paths)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(listPath)
+	args := append(append([]string{"-v", "error"}, concatDemuxerArgs...), "-i", listPath, "-c:a", "flac", "-f", "flac", "pipe:1")
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", args...)
+	w.Header().Set("Content-Type", "audio/flac")
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		return fmt.Errorf("ffmpeg failed to join %s: %w", meta.SourceFilename, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// coverImageExtensions 是封面图片可用的扩展名，按优先顺序排列
+var coverImageExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// coverFingerprint 标记封面的处理方式，修改后已写好标签的缓存会重新生成
+const coverFingerprint = "stripped-v1"
+
+// outputMuxers 把发布文件的扩展名映射到 ffmpeg 的封装格式；coverArt 表示该格式能否嵌入封面。
+// 网站目前只发布 m4a，其余格式尚无设置可以选择，这里只保证写标签的方式已经确定
+var outputMuxers = map[string]struct {
+	format   string
+	coverArt bool
+}{
+	".m4a":  {"mp4", true},
+	".mp3":  {"mp3", true},
+	".flac": {"flac", true},
+	".ogg":  {"ogg", false},
+	".opus": {"opus", false},
+}
+
+// audioTags 是写入发布文件的标签
+type audioTags struct {
+	Title       string
+	Description string
+	Artist      string
+	Date        string
+	Location    string // 地点名称
+	ISO6709     string // 坐标，例如 "+22.5431+114.0579/"
+	License     string
+	CoverPath   string // 封面图片的绝对路径，为空时不嵌入
+}
+
+// newAudioTags 根据录音元数据生成标签，作者和许可证未单独设置时使用全局设置
+func newAudioTags(meta AudioMetadata, settings Settings) audioTags {
+	tags := audioTags{
+		Title:       meta.Title,
+		Description: meta.Description,
+		Artist:      meta.Artist,
+		Location:    meta.Location,
+		License:     meta.License,
+		CoverPath:   findCoverImage(meta),
+	}
+	if tags.Artist == "" {
+		tags.Artist = settings.Tags.Artist
+	}
+	if tags.License == "" {
+		tags.License = settings.Tags.License
+	}
+	if !meta.RecordDate.IsZero() {
+		tags.Date = meta.RecordDate.Format(time.RFC3339)
+	}
+	if meta.Latitude != nil && meta.Longitude != nil {
+		tags.ISO6709 = iso6709(*meta.Latitude, *meta.Longitude)
+	}
+	return tags
+}
+
+// iso6709 把经纬度格式化为 ISO 6709 字符串
+func iso6709(lat, lon float64) string {
+	return fmt.Sprintf("%+08.4f%+09.4f/", lat, lon)
+}
+
+// findCoverImage 查找录音的封面：优先使用元数据中指定的图片，其次是与源文件同名的图片，
+// 最后是所在文件夹中的 cover 或 folder 图片。返回绝对路径，找不到时返回空字符串
+func findCoverImage(meta AudioMetadata) string {
+	var candidates []string
+	if meta.CoverImage != "" {
+		candidates = append(candidates, filepath.Join(wavDir, filepath.Clean("/"+meta.CoverImage)))
+	}
+	base := sidecarBase(meta.SourceFilename)
+	dir := filepath.Dir(meta.SourceFilename)
+	for _, ext := range coverImageExtensions {
+		candidates = append(candidates, filepath.Join(wavDir, base+ext))
+	}
+	for _, name := range []string{"cover", "folder"} {
+		for _, ext := range coverImageExtensions {
+			candidates = append(candidates, filepath.Join(wavDir, dir, name+ext))
+		}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// metadataArgs 返回写入标签的 ffmpeg 参数。MP4 只保留标准键，因此许可证写入 copyright，
+// 地点名称写入 comment，坐标写入 location (©xyz)
+func (t audioTags) metadataArgs() []string {
+	var args []string
+	set := func(key, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			args = append(args, "-metadata", key+"="+value)
+		}
+	}
+	set("title", t.Title)
+	set("artist", t.Artist)
+	set("date", t.Date)
+	set("description", t.Description)
+	set("comment", t.Location)
+	set("copyright", t.License)
+	set("location", t.ISO6709)
+	return args
+}
+
+// newTagJob 生成只重新封装、不重新编码的写标签任务
+func newTagJob(tags audioTags, ext string) encodeJob {
+	job := encodeJob{OutputArgs: []string{"-map_metadata", "-1", "-map", "0:a", "-c", "copy"}}
+	if tags.CoverPath != "" && outputMuxers[ext].coverArt {
+		job.CoverPath = tags.CoverPath
+		job.OutputArgs = append(job.OutputArgs, "-map", "1:v:0", "-disposition:v:0", "attached_pic")
+	}
+	if ext == ".mp3" {
+		job.OutputArgs = append(job.OutputArgs, "-id3v2_version", "3")
+	}
+	job.OutputArgs = append(job.OutputArgs, tags.metadataArgs()...)
+	return job
+}
+
+// publishTagged 把缓存中的文件写入标签和封面后发布到 dist/assets/<kind>，返回相对于 dist 的路径。
+// 写好标签的文件缓存在 taggedDir/<kind> 中，以输入文件的哈希和标签内容为键，标签不变时不会重新封装。
+// 写标签失败时退回到直接复制，不影响发布
+func publishTagged(manifest *CacheManifest, srcPath, kind, relPath string, tags audioTags) (string, error) {
+	ext := strings.ToLower(filepath.Ext(relPath))
+	muxer, ok := outputMuxers[ext]
+	if !ok {
+		return copyToDistAssets(srcPath, kind, relPath)
+	}
+	cacheRelPath := filepath.Join(kind, relPath)
+	cachePath := filepath.Join(taggedDir, cacheRelPath)
+	sourceHash, err := hashFile(srcPath)
+	if err != nil {
+		return "", err
+	}
+	job := newTagJob(tags, ext)
+	fingerprint := job.fingerprint()
+	if job.CoverPath != "" {
+		// The cover is not part of the job arguments, so replacing the image must change the fingerprint too
+		coverHash, err := hashFile(job.CoverPath)
+		if err != nil {
+			return "", err
+		}
+		fingerprint = encoderFingerprint([]string{fingerprint, coverHash, coverFingerprint})
+	}
+	_, statErr := os.Stat(cachePath)
+	if statErr != nil || !manifest.isFresh(cacheRelPath, sourceHash, fingerprint) {
+		if job.CoverPath != "" {
+			// Photos carry EXIF coordinates, so only a copy without metadata is embedded
+			coverPath, err := strippedTempImage(job.CoverPath)
+			if err != nil {
+				log.Printf("Warning: Failed to strip metadata from cover %s: %v. Publishing without cover.", job.CoverPath, err)
+				tags.CoverPath = ""
+				job = newTagJob(tags, ext)
+			} else {
+				defer os.Remove(coverPath)
+				job.CoverPath = coverPath
+			}
+		}
+		if err := runEncodeJob(srcPath, cachePath, job, muxer.format); err != nil {
+			log.Printf("Warning: Failed to write tags to %s: %v. Copying without tags.", cacheRelPath, err)
+			return copyToDistAssets(srcPath, kind, relPath)
+		}
+		manifest.record(cacheRelPath, sourceHash, fingerprint)
+		if err := manifest.save(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return copyToDistAssets(cachePath, kind, relPath)
+}
+
+// taggedKinds 是 publishTagged 缓存的发布文件种类，即 taggedDir 下的子目录
+var taggedKinds = []string{"audio", "preview"}
+
+// removeTaggedCache 删除录音已写好标签的缓存文件，录音改名或删除时使用，下次生成时重新写入
+func removeTaggedCache(m4aRelPath string) {
+	for _, kind := range taggedKinds {
+		path := filepath.Join(taggedDir, kind, m4aRelPath)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to delete tagged cache %s: %v", path, err)
+		}
+		// Drop the folder once its last file is gone, leaving the kind directory itself in place
+		for dir := filepath.Dir(path); dir != filepath.Join(taggedDir, kind); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	if err := updateCacheManifest(taggedDir, func(m *CacheManifest) {
+		for _, kind := range taggedKinds {
+			m.remove(filepath.Join(kind, m4aRelPath))
+		}
+	}); err != nil {
+		log.Printf("Warning: Failed to update tagged cache manifest: %v", err)
+	}
+}
@@ -4,7 +4,66 @@ import "time"
 
 // Settings 定义了网站的全局配置
 type Settings struct {
-	Domain string `json:"domain"`
+	Domain  string          `json:"domain"`
+	HLS     HLSSettings     `json:"hls"`
+	Downmix DownmixSettings `json:"downmix"`
+	QC      QCSettings      `json:"qc"`
+	Indices IndicesSettings `json:"indices"`
+	Tags    TagSettings     `json:"tags"`
+	Events  EventSettings   `json:"events"`
+	// Spectrogram 控制长录音的假彩色频谱图
+	Spectrogram SpectrogramSettings `json:"spectrogram"`
+	// Gazetteer 是根据坐标推荐地址用的本地地名表
+	Gazetteer GazetteerSettings `json:"gazetteer"`
+	// Weather 描述导入天气用的气象站 CSV 日志
+	Weather WeatherSettings `json:"weather"`
+	// Analyzers 是扫描时依次运行的外部分析器，协议见 ExternalAnalyzerSettings
+	Analyzers []ExternalAnalyzerSettings `json:"analyzers"`
+	// Calibration 把录音机名称映射到校准值：dB SPL = dBFS + 校准值。
+	// 录音机名称不区分大小写，"default" 用于没有单独校准值的录音机
+	Calibration map[string]float64 `json:"calibration"`
+}
+
+// IndicesSettings 定义声学生态指数的计算参数，未设置的项使用 soundecology 的默认值
+type IndicesSettings struct {
+	PerMinute        bool     `json:"per_minute"`         // 是否保存每分钟的指数
+	FFTSize          int      `json:"fft_size"`           // 默认 512
+	ADIMaxFreq       float64  `json:"adi_max_freq"`       // ADI/AEI 的最高频率，默认 10000 Hz
+	ADIFreqStep      float64  `json:"adi_freq_step"`      // ADI/AEI 的频带宽度，默认 1000 Hz
+	ADIThresholdDBFS float64  `json:"adi_threshold_dbfs"` // ADI/AEI 的能量阈值，默认 -50 dBFS
+	BIBand           FreqBand `json:"bi_band"`            // 默认 2000-8000 Hz
+	NDSIAnthroBand   FreqBand `json:"ndsi_anthro_band"`   // 默认 1000-2000 Hz
+	NDSIBioBand      FreqBand `json:"ndsi_bio_band"`      // 默认 2000-11000 Hz
+}
+
+// TagSettings 是写入发布文件的默认标签，录音自己的设置优先
+type TagSettings struct {
+	Artist  string `json:"artist"`
+	License string `json:"license"` // 例如 "CC BY-NC 4.0"
+}
+
+// QCSettings 定义质量检查的阈值，超过阈值即不通过。MaxPeakDBFS 为空时使用默认值，其余阈值为 0 时
+// 使用默认值（计数类阈值默认为 0，即不允许出现）
+type QCSettings struct {
+	MaxPeakDBFS       *float64 `json:"max_peak_dbfs,omitempty"` // 默认 -0.1；设为 0 时允许恰好满幅的峰值
+	MaxClipCount      int      `json:"max_clip_count"`
+	MaxDCOffset       float64  `json:"max_dc_offset"` // 相对满幅，默认 0.01
+	MaxDropouts       int      `json:"max_dropouts"`
+	MaxSilenceSeconds float64  `json:"max_silence_seconds"` // 单段数字静音的最长时长，默认 5 秒
+	RefuseToPublish   bool     `json:"refuse_to_publish"`   // 生成静态网站时跳过未通过检查的录音
+}
+
+// DownmixSettings 定义多通道录音在未单独设置时如何转为立体声
+type DownmixSettings struct {
+	Mode     string `json:"mode"`      // 默认的 ChannelMix 取值，为空时使用 "stereo"
+	SofaPath string `json:"sofa_path"` // "binaural" 模式使用的 HRTF (SOFA) 文件
+}
+
+// HLSSettings 控制是否为长录音额外生成 HLS 分段输出
+type HLSSettings struct {
+	Enabled            bool    `json:"enabled"`
+	SegmentSeconds     int     `json:"segment_seconds"`      // 每个分段的时长，默认 10 秒
+	MinDurationSeconds float64 `json:"min_duration_seconds"` // 只为不短于该时长的录音生成，默认 10 分钟
 }
 
 // AboutContent 定义了“关于”页面的数据结构
@@ -22,24 +81,78 @@ type AboutPageData struct {
 // EditPageData is used to pass data to the edit.html template
 type EditPageData struct {
 	AudioMetadata
-	BaseFilename string
-	FolderPath   string
+	BaseFilename      string
+	FolderPath        string
+	ChannelMixOptions []ChannelMixOption
+	SourceFormat      sourceFormat
+	PlaceLabels       []string // 地点登记表中所有地点的完整名称，用于位置输入框的自动补全
+	LocationLabel     string   // 位置输入框的当前值
+	PrivacyLevels     []struct{ Key, Label string }
+	PublishedPrivacy  string          // 与所在地点的设置合并后实际发布的隐私级别
+	RunningAnalyzers  map[string]bool // 正在后台重新运行的外部分析器
+}
+
+// ChannelMixOption 是编辑页中通道选择下拉框的一项
+type ChannelMixOption struct {
+	Value string
+	Label string
 }
 
 // AudioMetadata 定义了音频文件的元数据结构
 type AudioMetadata struct {
-	SourceFilename       string    `json:"source_filename"`
-	Title                string    `json:"title"`
-	Description          string    `json:"description"`
-	Location             string    `json:"location"`
-	RecordDate           time.Time `json:"record_date"` // Use default time.Time
-	DurationSeconds      float64   `json:"duration_seconds"`
-	SourceFileSizeMB     float64   `json:"source_file_size_mb"`     // 源文件大小(MB)
-	CompressedFileSizeMB float64   `json:"compressed_file_size_mb"` // 压缩后文件大小(MB)
-	CompressedAudioPath  string    `json:"compressed_audio_path"`   // 相对于dist目录的路径
-	TechInfo             struct {
-		SampleRate int `json:"sample_rate"`
-		BitDepth   int `json:"bit_depth"`
-		Channels   int `json:"channels"`
+	SourceFilename           string           `json:"source_filename"`
+	SplitParts               []string         `json:"split_parts,omitempty"`          // 录音机分割的各段（含第一段），按顺序拼接为一条录音
+	ProposedSplitParts       []string         `json:"proposed_split_parts,omitempty"` // 扫描发现、尚未确认的分段，确认后移入 SplitParts
+	DeclinedSplitParts       []string         `json:"declined_split_parts,omitempty"` // 在管理界面中否认的分组，不再提示
+	Title                    string           `json:"title"`
+	Description              string           `json:"description"`
+	Location                 string           `json:"location"`
+	PlaceID                  string           `json:"place_id,omitempty"` // 引用地点登记表时 Location 为地点名称
+	Privacy                  *LocationPrivacy `json:"privacy,omitempty"`  // 位置隐私，与所在地点的设置取较严格的一个
+	Latitude                 *float64         `json:"latitude,omitempty"` // WGS 84 坐标
+	Longitude                *float64         `json:"longitude,omitempty"`
+	Address                  *GeoAddress      `json:"address,omitempty"`                    // 结构化地址，可由地名表根据坐标推荐
+	Artist                   string           `json:"artist,omitempty"`                     // 录音者，为空时使用全局设置
+	License                  string           `json:"license,omitempty"`                    // 许可证，为空时使用全局设置
+	CoverImage               string           `json:"cover_image,omitempty"`                // 相对于 wav 目录的封面图片，为空时自动查找
+	RecordDate               time.Time        `json:"record_date"`                          // Use default time.Time
+	DurationSeconds          float64          `json:"duration_seconds"`                     // 源文件时长
+	PublishedDurationSeconds float64          `json:"published_duration_seconds,omitempty"` // 应用裁剪点后发布文件的时长，生成网站时计算
+	SourceFileSizeMB         float64          `json:"source_file_size_mb"`                  // 源文件大小(MB)
+	CompressedFileSizeMB     float64          `json:"compressed_file_size_mb"`              // 压缩后文件大小(MB)
+	CompressedAudioPath      string           `json:"compressed_audio_path"`                // 相对于dist目录的路径
+	TechInfo                 struct {
+		SampleRate    int      `json:"sample_rate"`
+		BitDepth      int      `json:"bit_depth"`
+		Channels      int      `json:"channels"`
+		ChannelLayout string   `json:"channel_layout,omitempty"` // ffprobe 识别的通道布局，例如 "stereo"、"4.0"
+		ChannelNames  []string `json:"channel_names,omitempty"`  // iXML 中记录的各通道名称，按交错顺序
 	} `json:"tech_info"`
+	Edit                EditPoints                   `json:"edit"`                            // 转码时应用的裁剪与淡入淡出，不修改源文件
+	PreviewStartSeconds *float64                     `json:"preview_start_seconds,omitempty"` // 试听片段起点（相对裁剪后的音频），为空时按响度自动选择
+	PreviewClipPath     string                       `json:"preview_clip_path,omitempty"`     // 相对于dist目录的试听片段路径
+	HLSPlaylistPath     string                       `json:"hls_playlist_path,omitempty"`     // 相对于dist目录的 HLS 播放列表路径
+	ChannelMix          string                       `json:"channel_mix,omitempty"`           // 发布哪对通道或如何缩混，为空时使用全局设置
+	SourceHash          string                       `json:"source_hash,omitempty"`           // 源文件内容的 SHA-256，各项分析结果以此判断是否过期
+	SourceStat          string                       `json:"source_stat,omitempty"`           // 计算哈希时源文件的大小与修改时间
+	QC                  *QCReport                    `json:"qc,omitempty"`                    // 源文件质量检查结果
+	Indices             *AcousticIndices             `json:"indices,omitempty"`               // 声学生态指数
+	Recorder            string                       `json:"recorder,omitempty"`              // 录音机名称，默认取自 BWF bext 的 Originator，用于查找校准值
+	Levels              *LevelStats                  `json:"levels,omitempty"`                // A/C 计权声级统计
+	RecorderMarkers     []Marker                     `json:"recorder_markers,omitempty"`      // 从源文件 cue 块导入的标记，每次扫描重新读取
+	Markers             []Marker                     `json:"markers,omitempty"`               // 在管理界面中手动添加的标记，扫描时不会改动
+	Fingerprint         *AudioFingerprint            `json:"fingerprint,omitempty"`           // 用于查找重复录音的频谱指纹
+	Features            *AudioFeatures               `json:"features,omitempty"`              // 用于查找相似录音的特征向量
+	Events              *EventDetection              `json:"events,omitempty"`                // 自动检测的候选事件，接受后转为手动标记
+	External            map[string]*ExternalAnalysis `json:"external,omitempty"`              // 各外部分析器的结果，按分析器名称保存
+	Sky                 *SkyContext                  `json:"sky,omitempty"`                   // 录音开始时的日月状况，由时间和坐标计算
+	Weather             *WeatherConditions           `json:"weather,omitempty"`               // 从气象站日志导入的天气
+}
+
+// EditPoints 定义了非破坏性的裁剪点和淡入淡出时长，单位均为秒
+type EditPoints struct {
+	TrimInSeconds  float64 `json:"trim_in_seconds"`  // 从源文件的该位置开始
+	TrimOutSeconds float64 `json:"trim_out_seconds"` // 在源文件的该位置结束，0 表示到结尾
+	FadeInSeconds  float64 `json:"fade_in_seconds"`
+	FadeOutSeconds float64 `json:"fade_out_seconds"`
 }
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,6 +24,11 @@ var (
 	wavDir         string
 	jsonDir        string
 	m4aDir         string
+	previewDir     string
+	hlsDir         string
+	spectrogramDir string
+	taggedDir      string
+	placePhotoDir  string
 	distDir        = "dist"
 	assetsAudioDir = "dist/assets/audio"
 	staticDir      = "static"
@@ -27,7 +36,7 @@ var (
 )
 
 // specialJsonFiles 列出了所有非音频元数据的特殊 JSON 文件，在处理时需要跳过
-var specialJsonFiles = []string{"about.json", "settings.json"}
+var specialJsonFiles = []string{"about.json", "settings.json", "places.json"}
 
 func getUserTimeLocation() *time.Location {
 	tz, ok := os.LookupEnv("TZ")
@@ -106,18 +115,59 @@ func isSpecialJsonFile(filename string) bool {
 }
 
 func initAudioData() error {
-	wavFilesFound := make(map[string]bool)
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	// Find recorder split sets first: later parts are folded into the first part's sidecar
+	var sourceRelPaths []string
 	walkErr := filepath.Walk(wavDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".wav") {
+		if !info.IsDir() && isSourceAudio(info.Name()) {
+			relPath, err := filepath.Rel(wavDir, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+			}
+			sourceRelPaths = append(sourceRelPaths, relPath)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return fmt.Errorf("error walking through wav directory: %w", walkErr)
+	}
+	splitSets, proposedSplitSets := detectSplitSets(sourceRelPaths)
+	continuationOf := make(map[string]string)
+	for first, parts := range splitSets {
+		for _, part := range parts[1:] {
+			continuationOf[part] = first
+		}
+	}
+
+	sourceBasesFound := make(map[string]bool) // Keyed by sidecarBase, so any extension or case variant counts
+	walkErr = filepath.Walk(wavDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isSourceAudio(info.Name()) {
 			relPath, err := filepath.Rel(wavDir, path)
 			if err != nil {
 				return fmt.Errorf("failed to get relative path for %s: %w", path, err)
 			}
-			wavFilesFound[relPath] = true
-			jsonFileRelPath := strings.TrimSuffix(relPath, filepath.Ext(relPath)) + ".json"
+			if first, ok := continuationOf[relPath]; ok {
+				// A sidecar left over from before the set was detected would show up as a separate recording
+				ownJsonPath := filepath.Join(jsonDir, sidecarBase(relPath)+".json")
+				if own, err := loadAudioMetadata(ownJsonPath); err == nil && own.SourceFilename == relPath {
+					log.Printf("%s is part of the split recording %s, removing its own metadata file", relPath, first)
+					if err := os.Remove(ownJsonPath); err != nil {
+						log.Printf("Warning: Failed to remove %s: %v", ownJsonPath, err)
+					}
+				}
+				return nil
+			}
+			sourceBasesFound[sidecarBase(relPath)] = true
+			jsonFileRelPath := sidecarBase(relPath) + ".json"
 			jsonFilePath := filepath.Join(jsonDir, jsonFileRelPath)
 			if err := os.MkdirAll(filepath.Dir(jsonFilePath), 0755); err != nil {
 				return fmt.Errorf("failed to create directory for json file %s: %w", jsonFilePath, err)
@@ -164,9 +214,25 @@ func initAudioData() error {
 					metadata.SourceFileSizeMB = float64(info.Size()) / (1024 * 1024)
 				}
 			}
+			// "a.wav" and "a.flac" would share one sidecar, keep the one that already owns it
+			if !newFile && metadata.SourceFilename != relPath {
+				owner := filepath.Join(wavDir, metadata.SourceFilename)
+				if _, err := os.Stat(owner); err == nil && !sameFile(owner, path) {
+					log.Printf("Warning: Skipping %s, its metadata file already belongs to %s. Rename one of them.", relPath, metadata.SourceFilename)
+					return nil
+				}
+			}
+
+			metadata.SourceFilename = relPath // Ensure source filename is up-to-date
+			splitChanged := !slices.Equal(metadata.SplitParts, splitSets[relPath])
+			metadata.SplitParts = splitSets[relPath]
+			metadata.ProposedSplitParts = nil
+			if proposal := proposedSplitSets[relPath]; !slices.Equal(proposal, metadata.DeclinedSplitParts) {
+				metadata.ProposedSplitParts = proposal
+			}
 
 			// Get tech info only if it's a new file or seems to be missing
-			if newFile || metadata.TechInfo.SampleRate == 0 {
+			if newFile || metadata.TechInfo.SampleRate == 0 || metadata.DurationSeconds == 0 || splitChanged {
 				duration, sampleRate, bitDepth, channels, err := getAudioTechInfo(path)
 				if err != nil {
 					log.Printf("Warning: Failed to get tech info for %s: %v", info.Name(), err)
@@ -177,11 +243,62 @@ func initAudioData() error {
 					metadata.TechInfo.Channels = channels
 				}
 			}
+			if len(metadata.SplitParts) > 1 {
+				if newFile || splitChanged {
+					log.Printf("Joining split recording %s (%d parts)", relPath, len(metadata.SplitParts))
+				}
+				duration, sizeMB, err := splitSetTotals(metadata.SplitParts)
+				if err != nil {
+					log.Printf("Warning: Failed to read split parts of %s: %v", relPath, err)
+				} else {
+					metadata.DurationSeconds = duration
+					metadata.SourceFileSizeMB = sizeMB
+				}
+			}
+			if newFile || metadata.TechInfo.ChannelLayout == "" {
+				layout, names, err := getChannelInfo(path)
+				if err != nil {
+					log.Printf("Warning: Failed to get channel info for %s: %v", info.Name(), err)
+				}
+				metadata.TechInfo.ChannelLayout = layout
+				metadata.TechInfo.ChannelNames = names
+			}
+			if format, _ := sourceFormatFor(relPath); newFile && format.RIFF && metadata.Recorder == "" {
+				if bext, err := readBext(path); err != nil {
+					log.Printf("Warning: Failed to read bext of %s: %v", info.Name(), err)
+				} else if bext != nil {
+					metadata.Recorder = bext.Originator
+				}
+			}
+			if format, _ := sourceFormatFor(relPath); format.RIFF {
+				// Replaced wholesale so re-scans never duplicate imported markers
+				markers, err := readRecorderMarkers(metadata)
+				if err != nil {
+					log.Printf("Warning: Failed to read cue markers of %s: %v", info.Name(), err)
+				} else {
+					if len(markers) != len(metadata.RecorderMarkers) {
+						log.Printf("Imported %d recorder markers from %s", len(markers), relPath)
+					}
+					metadata.RecorderMarkers = markers
+				}
+			} else {
+				metadata.RecorderMarkers = nil
+			}
+			refreshSourceAnalyses(&metadata, settings)
+			runExternalAnalyzers(&metadata, settings)
+			refreshSkyContext(&metadata)
+			if settings.Gazetteer.AutoFill && metadata.Address.isEmpty() && metadata.Latitude != nil && metadata.Longitude != nil {
+				if suggestion, err := suggestAddress(settings.Gazetteer, *metadata.Latitude, *metadata.Longitude); err != nil {
+					log.Printf("Warning: Failed to look up address of %s: %v", relPath, err)
+				} else if suggestion != nil {
+					metadata.Address = &suggestion.Address
+					log.Printf("Filled address of %s: %s", relPath, metadata.Address)
+				}
+			}
 
 			// Always ensure these fields are correct
-			aacRelPath := strings.TrimSuffix(relPath, filepath.Ext(relPath)) + ".m4a"
+			aacRelPath := sidecarBase(relPath) + ".m4a"
 			metadata.CompressedAudioPath = filepath.ToSlash(filepath.Join("assets", "audio", aacRelPath))
-			metadata.SourceFilename = relPath // Ensure source filename is up-to-date
 			metadata.Title, metadata.Description, metadata.Location = strings.ReplaceAll(metadata.Title, "\r", ""), strings.ReplaceAll(metadata.Description, "\r", ""), strings.ReplaceAll(metadata.Location, "\r", "")
 
 			// Write back the JSON file
@@ -212,21 +329,11 @@ func initAudioData() error {
 			if err != nil {
 				return err
 			}
-			wavRelPath := strings.TrimSuffix(jsonRelPath, ".json") + ".wav"
-			hasWav := false
-			if _, found := wavFilesFound[wavRelPath]; found {
-				hasWav = true
-			} else {
-				wavRelPathUpper := strings.TrimSuffix(jsonRelPath, ".json") + ".WAV"
-				if _, foundUpper := wavFilesFound[wavRelPathUpper]; foundUpper {
-					hasWav = true
-				}
-			}
-
+			hasSource := sourceBasesFound[strings.TrimSuffix(jsonRelPath, ".json")]
 			hasM4aCache := m4aCacheExists(jsonRelPath)
 
-			// If neither WAV nor M4A cache exists, then it's a true orphan
-			if !hasWav && !hasM4aCache {
+			// If neither the source nor the M4A cache exists, then it's a true orphan
+			if !hasSource && !hasM4aCache {
 				log.Printf("Orphan json file found, deleting: %s", path)
 				if err := os.Remove(path); err != nil {
 					log.Printf("Failed to delete orphan json %s: %v", path, err)
@@ -245,7 +352,7 @@ func syncAudioData() error {
 			if err != nil {
 				return err
 			}
-			if !info.IsDir() && (strings.HasSuffix(strings.ToLower(info.Name()), ".wav") || strings.HasSuffix(strings.ToLower(info.Name()), ".m4a")) {
+			if !info.IsDir() && (isSourceAudio(info.Name()) || strings.EqualFold(filepath.Ext(info.Name()), ".m4a")) {
 				// Determine the base directory (wavDir or m4aDir) to correctly calculate the relative path
 				var baseDir string
 				if strings.HasPrefix(path, wavDir) {
@@ -259,7 +366,7 @@ func syncAudioData() error {
 					return fmt.Errorf("failed to get relative path for %s: %w", path, err)
 				}
 
-				jsonFileRelPath := strings.TrimSuffix(relPath, filepath.Ext(relPath)) + ".json"
+				jsonFileRelPath := sidecarBase(relPath) + ".json"
 				jsonFilePath := filepath.Join(jsonDir, jsonFileRelPath)
 
 				if _, err := os.Stat(jsonFilePath); os.IsNotExist(err) {
@@ -280,6 +387,7 @@ func syncAudioData() error {
 				if !metadata.RecordDate.Equal(birthTime) {
 					log.Printf("Syncing time for %s. Old: %s, New: %s", relPath, metadata.RecordDate.Format(time.RFC3339), birthTime.Format(time.RFC3339))
 					metadata.RecordDate = birthTime
+					refreshSkyContext(&metadata)
 					updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
 					if err != nil {
 						log.Printf("Failed to marshal json for %s during time sync: %v", info.Name(), err)
@@ -301,9 +409,10 @@ func syncAudioData() error {
 
 func getAudioTechInfo(audioPath string) (duration float64, sampleRate, bitDepth, channels int, err error) {
 	type FFProbeStream struct {
-		SampleRate    string `json:"sample_rate"`
-		Channels      int    `json:"channels"`
-		BitsPerSample int    `json:"bits_per_sample"`
+		SampleRate       string `json:"sample_rate"`
+		Channels         int    `json:"channels"`
+		BitsPerSample    int    `json:"bits_per_sample"`
+		BitsPerRawSample string `json:"bits_per_raw_sample"` // FLAC 和 ALAC 只在这里给出位深度
 	}
 	type FFProbeFormat struct {
 		DurationStr string `json:"duration"`
@@ -326,12 +435,42 @@ func getAudioTechInfo(audioPath string) (duration float64, sampleRate, bitDepth,
 	for _, stream := range ffprobeData.Streams {
 		if stream.SampleRate != "" {
 			sampleRate, _ = strconv.Atoi(stream.SampleRate)
-			return duration, sampleRate, stream.BitsPerSample, stream.Channels, nil
+			bitDepth = stream.BitsPerSample
+			if bitDepth == 0 {
+				bitDepth, _ = strconv.Atoi(stream.BitsPerRawSample)
+			}
+			return duration, sampleRate, bitDepth, stream.Channels, nil
 		}
 	}
 	return duration, 0, 0, 0, fmt.Errorf("no valid audio stream found in %s", audioPath)
 }
 
+// getChannelInfo 返回 ffprobe 识别的通道布局，以及 WAV 的 iXML 中记录的各通道名称
+func getChannelInfo(audioPath string) (layout string, names []string, err error) {
+	type FFProbeOutput struct {
+		Streams []struct {
+			ChannelLayout string `json:"channel_layout"`
+		} `json:"streams"`
+	}
+	stdout, stderr, cmdErr := runCommand("ffprobe", "-v", "quiet", "-print_format", "json", "-select_streams", "a:0", "-show_entries", "stream=channel_layout", audioPath)
+	if cmdErr != nil {
+		return "", nil, fmt.Errorf("ffprobe command failed: %v, stderr: %s", cmdErr, stderr)
+	}
+	var ffprobeData FFProbeOutput
+	if err := json.Unmarshal([]byte(stdout), &ffprobeData); err != nil {
+		return "", nil, fmt.Errorf("failed to unmarshal ffprobe json output: %w, output: %s", err, stdout)
+	}
+	if len(ffprobeData.Streams) > 0 {
+		layout = ffprobeData.Streams[0].ChannelLayout
+	}
+	if format, _ := sourceFormatFor(audioPath); format.RIFF {
+		if names, err = readIXMLTrackNames(audioPath); err != nil {
+			return layout, nil, err
+		}
+	}
+	return layout, names, nil
+}
+
 func m4aCacheExists(jsonRelPath string) bool {
 	m4aCacheRelPath := strings.TrimSuffix(jsonRelPath, ".json") + ".m4a"
 	m4aCachePath := filepath.Join(m4aDir, m4aCacheRelPath)
@@ -339,28 +478,68 @@ func m4aCacheExists(jsonRelPath string) bool {
 	return err == nil
 }
 
-func transcodeToAac(inputPath, outputPath string) error {
+// transcodeToAac 先转码到临时文件，校验时长后再原子地重命名到 outputPath，
+// 中断的转码不会在缓存目录留下不完整的 m4a
+func transcodeToAac(inputPath, outputPath string, job encodeJob) error {
+	return runEncodeJob(inputPath, outputPath, job, "mp4")
+}
+
+// runEncodeJob 以 format 封装格式运行 ffmpeg 任务：先写入临时文件，校验时长后再替换目标文件
+func runEncodeJob(inputPath, outputPath string, job encodeJob, format string) error {
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 		return fmt.Errorf("failed to create output directory %s: %w", filepath.Dir(outputPath), err)
 	}
-	_, stderr, err := runCommand("ffmpeg", "-i", inputPath, "-y", "-vn", "-c:a", "aac", "-vbr", "4", outputPath)
+	tmpPath := outputPath + transcodeTempSuffix
+	defer os.Remove(tmpPath) // No-op once the file has been renamed into place
+
+	// The temp suffix hides the container type from ffmpeg, so name it explicitly
+	job.OutputArgs = append(append([]string{}, job.OutputArgs...), "-f", format)
+	_, stderr, err := runCommand("ffmpeg", job.args(inputPath, tmpPath)...)
 	if err != nil {
 		return fmt.Errorf("ffmpeg transcode failed: %v, stderr: %s", err, stderr)
 	}
+	if err := verifyTranscode(inputPath, tmpPath, job.ExpectedDuration); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", tmpPath, err)
+	}
+	return nil
+}
+
+// verifyTranscode 比较转码结果与预期时长，防止截断的文件进入缓存。
+// expectedDuration 未知时以源文件时长为准。
+func verifyTranscode(sourcePath, outputPath string, expectedDuration float64) error {
+	if expectedDuration <= 0 {
+		sourceDuration, _, _, _, err := getAudioTechInfo(sourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to probe source %s: %w", sourcePath, err)
+		}
+		expectedDuration = sourceDuration
+	}
+	outputDuration, _, _, _, err := getAudioTechInfo(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe transcoded file %s: %w", outputPath, err)
+	}
+	// AAC adds a little encoder delay and padding, allow for it
+	tolerance := math.Max(0.5, expectedDuration*0.005)
+	if math.Abs(expectedDuration-outputDuration) > tolerance {
+		return fmt.Errorf("transcoded duration %.2fs does not match expected duration %.2fs", outputDuration, expectedDuration)
+	}
 	return nil
 }
 
-// copyM4aToDist 负责将 data/m4a 中的缓存文件复制到 dist/assets/audio
-func copyM4aToDist(srcM4aPath, relPath string) (string, error) {
-	dstAacRelPath := filepath.Join("assets", "audio", relPath)
-	dstAacPath := filepath.Join(distDir, dstAacRelPath)
-	if err := os.MkdirAll(filepath.Dir(dstAacPath), 0755); err != nil {
-		return "", fmt.Errorf("failed to create output directory %s: %w", filepath.Dir(dstAacPath), err)
+// copyToDistAssets 将缓存文件复制到 dist/assets/<kind>，返回相对于 dist 的路径
+func copyToDistAssets(srcPath, kind, relPath string) (string, error) {
+	dstRelPath := filepath.Join("assets", kind, relPath)
+	dstPath := filepath.Join(distDir, dstRelPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory %s: %w", filepath.Dir(dstPath), err)
 	}
-	if err := copyFile(srcM4aPath, dstAacPath); err != nil {
-		return "", fmt.Errorf("failed to copy %s to %s: %w", srcM4aPath, dstAacPath, err)
+	if err := copyFile(srcPath, dstPath); err != nil {
+		return "", fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
 	}
-	return filepath.ToSlash(dstAacRelPath), nil
+	return filepath.ToSlash(dstRelPath), nil
 }
 
 func loadAllMetadataGroupedByFolder() (map[string][]AudioMetadata, error) {
@@ -419,7 +598,7 @@ func loadAudioMetadata(path string) (AudioMetadata, error) {
 }
 
 func getMetadataBySourceFilename(filename string) (AudioMetadata, error) {
-	jsonFileRelPath := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+	jsonFileRelPath := sidecarBase(filename) + ".json"
 	jsonFilePath := filepath.Join(jsonDir, jsonFileRelPath)
 	return loadAudioMetadata(jsonFilePath)
 }
@@ -499,8 +678,116 @@ func copyFile(src, dst string) error {
 	return out.Close()
 }
 
+// writeCSV 写入 CSV 文件，开头带 UTF-8 BOM 以便 Excel 正确识别中文
+func writeCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("\ufeff"); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// copyDir 递归复制目录
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(destPath, info.Mode())
+		}
+		return copyFile(path, destPath)
+	})
+}
+
 func add(a, b int) int { return a + b }
 
+// parseFloatFormValue 解析表单中的数值字段，空值或无效值返回 0
+func parseFloatFormValue(r *http.Request, name string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue(name)), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// parseAddressFormValue 读取表单中的结构化地址，各项都为空时返回 nil
+func parseAddressFormValue(r *http.Request) *GeoAddress {
+	address := &GeoAddress{
+		Site:     strings.TrimSpace(r.FormValue("address_site")),
+		District: strings.TrimSpace(r.FormValue("address_district")),
+		Province: strings.TrimSpace(r.FormValue("address_province")),
+		Country:  strings.TrimSpace(r.FormValue("address_country")),
+	}
+	if address.isEmpty() {
+		return nil
+	}
+	return address
+}
+
+// parsePrivacyFormValue 读取位置隐私级别，未选择或选择准确位置时返回 nil
+func parsePrivacyFormValue(r *http.Request) *LocationPrivacy {
+	level := r.FormValue("privacy_level")
+	if privacyRank(level) == 0 {
+		return nil
+	}
+	privacy := &LocationPrivacy{Level: level}
+	if level == privacyRounded {
+		privacy.RoundKm = math.Max(parseFloatFormValue(r, "privacy_round_km"), 0)
+	}
+	return privacy
+}
+
+// parseCoordinatesFormValue 读取表单中的经纬度，任一项为空或超出范围时两者都返回 nil
+func parseCoordinatesFormValue(r *http.Request) (lat, lon *float64) {
+	if strings.TrimSpace(r.FormValue("latitude")) == "" || strings.TrimSpace(r.FormValue("longitude")) == "" {
+		return nil, nil
+	}
+	la, lo := parseFloatFormValue(r, "latitude"), parseFloatFormValue(r, "longitude")
+	if math.Abs(la) > 90 || math.Abs(lo) > 180 {
+		return nil, nil
+	}
+	return &la, &lo
+}
+
+// parseMarkersFormValue 读取编辑页面中逐行的 marker_time / marker_label / marker_duration / marker_note，
+// 名称为空或时间无法解析的行被忽略
+func parseMarkersFormValue(r *http.Request) []Marker {
+	times, labels := r.Form["marker_time"], r.Form["marker_label"]
+	durations, notes := r.Form["marker_duration"], r.Form["marker_note"]
+	var markers []Marker
+	for i := 0; i < len(times) && i < len(labels); i++ {
+		label := strings.TrimSpace(labels[i])
+		t, ok := parseTimecode(times[i])
+		if label == "" || !ok {
+			continue
+		}
+		marker := Marker{TimeSeconds: t, Label: label}
+		if i < len(durations) {
+			marker.DurationSeconds, _ = strconv.ParseFloat(durations[i], 64)
+		}
+		if i < len(notes) {
+			marker.Note = strings.TrimSpace(notes[i])
+		}
+		markers = append(markers, marker)
+	}
+	sortMarkers(markers)
+	return markers
+}
+
 func updateAssociatedFileTimestamps(sourceFilename string, t time.Time) {
 	ext := filepath.Ext(sourceFilename)
 	baseFilename := strings.TrimSuffix(sourceFilename, ext)
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// riffChunk 描述 WAV (RIFF/RF64) 文件中的一个顶层数据块
+type riffChunk struct {
+	ID     string
+	Offset int64 // 数据起始位置（跳过 8 字节块头）
+	Size   int64
+}
+
+// listRiffChunks 列出 WAV 文件的所有顶层数据块，只读取块头，不读取 PCM 数据。
+// RF64 文件的 data 块大小记录在 ds64 块中。超出文件末尾的块大小截断到文件末尾。
+func listRiffChunks(r io.ReadSeeker) ([]riffChunk, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	riffID := string(header[0:4])
+	if (riffID != "RIFF" && riffID != "RF64") || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	// Sizes in the headers are not trusted beyond the end of the file, so a corrupt or truncated
+	// file can never make a reader allocate more than the file holds
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []riffChunk
+	var rf64DataSize int64 = -1
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read chunk header at %d: %w", offset, err)
+		}
+		chunk := riffChunk{
+			ID:     string(chunkHeader[0:4]),
+			Offset: offset + 8,
+			Size:   int64(binary.LittleEndian.Uint32(chunkHeader[4:8])),
+		}
+		if chunk.ID == "ds64" {
+			ds64 := make([]byte, 24)
+			if _, err := io.ReadFull(r, ds64); err == nil {
+				rf64DataSize = int64(binary.LittleEndian.Uint64(ds64[8:16]))
+			}
+		}
+		if chunk.ID == "data" && chunk.Size == 0xFFFFFFFF && rf64DataSize >= 0 {
+			chunk.Size = rf64DataSize
+		}
+		if chunk.Size > fileSize-chunk.Offset {
+			chunk.Size = max(fileSize-chunk.Offset, 0)
+		}
+		chunks = append(chunks, chunk)
+		// Chunks are padded to an even size
+		offset = chunk.Offset + chunk.Size + chunk.Size%2
+	}
+	return chunks, nil
+}
+
+// maxRiffMetadataChunkSize 是 readRiffChunk 读取的块的大小上限，iXML、bext 等元数据块远小于此
+const maxRiffMetadataChunkSize = 16 << 20
+
+// readRiffChunk 返回 WAV 文件中第一个 id 块的内容，文件中没有该块时返回 nil
+func readRiffChunk(path, id string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chunks, err := listRiffChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, chunk := range chunks {
+		if chunk.ID != id {
+			continue
+		}
+		if chunk.Size > maxRiffMetadataChunkSize {
+			return nil, fmt.Errorf("%s chunk of %s is too large (%d bytes)", id, path, chunk.Size)
+		}
+		data := make([]byte, chunk.Size)
+		if _, err := f.ReadAt(data, chunk.Offset); err != nil {
+			return nil, fmt.Errorf("failed to read %s chunk of %s: %w", id, path, err)
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// ixmlTrackList 对应 iXML 中的 TRACK_LIST，记录每个交错通道的名称
+type ixmlTrackList struct {
+	Tracks []struct {
+		ChannelIndex    int    `xml:"CHANNEL_INDEX"`
+		InterleaveIndex int    `xml:"INTERLEAVE_INDEX"`
+		Name            string `xml:"NAME"`
+	} `xml:"TRACK_LIST>TRACK"`
+}
+
+// readIXMLTrackNames 从 iXML 块中按交错顺序读取通道名称，没有 iXML 时返回 nil
+func readIXMLTrackNames(path string) ([]string, error) {
+	data, err := readRiffChunk(path, "iXML")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var doc ixmlTrackList
+	// iXML is often NUL-padded
+	if err := xml.Unmarshal([]byte(strings.TrimRight(string(data), "\x00")), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse iXML of %s: %w", path, err)
+	}
+	tracks := doc.Tracks
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].InterleaveIndex < tracks[j].InterleaveIndex })
+	names := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		names = append(names, strings.TrimSpace(t.Name))
+	}
+	return names, nil
+}
+
+// bextInfo 是 BWF bext 块中的文本字段
+type bextInfo struct {
+	Description         string
+	Originator          string
+	OriginatorReference string
+	OriginationDate     string // yyyy-mm-dd
+	OriginationTime     string // hh:mm:ss
+	TimeReference       uint64 // 自午夜起的采样数
+}
+
+func parseBext(data []byte) (bextInfo, error) {
+	if len(data) < 346 {
+		return bextInfo{}, fmt.Errorf("bext chunk too short")
+	}
+	field := func(b []byte) string {
+		return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+	}
+	return bextInfo{
+		Description:         field(data[0:256]),
+		Originator:          field(data[256:288]),
+		OriginatorReference: field(data[288:320]),
+		OriginationDate:     field(data[320:330]),
+		OriginationTime:     field(data[330:338]),
+		TimeReference:       binary.LittleEndian.Uint64(data[338:346]),
+	}, nil
+}
+
+// readBext 读取 WAV 文件的 bext 块，没有该块时返回 nil
+func readBext(path string) (*bextInfo, error) {
+	data, err := readRiffChunk(path, "bext")
+	if err != nil || data == nil {
+		return nil, err
+	}
+	info, err := parseBext(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bext of %s: %w", path, err)
+	}
+	return &info, nil
+}
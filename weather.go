@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeatherSettings 描述本地气象站导出的 CSV 日志。数值按以下单位读取：气温 °C、相对湿度 %、
+// 风速 m/s、风向 °、降水 mm、气压 hPa
+type WeatherSettings struct {
+	Columns          WeatherColumns `json:"columns"`
+	TimeLayout       string         `json:"time_layout"`                // Go 时间格式，为空时尝试常见格式；没有时区时按录音的时区（TZ）解释
+	ToleranceMinutes float64        `json:"tolerance_minutes"`          // 录音时间与最近一行记录的最大时间差，默认 30 分钟
+	StationLatitude  *float64       `json:"station_latitude,omitempty"` // 气象站坐标，设置后跳过距离过远的录音
+	StationLongitude *float64       `json:"station_longitude,omitempty"`
+	MaxDistanceKm    float64        `json:"max_distance_km"` // 录音与气象站的最大距离，默认 25 km
+}
+
+// WeatherColumns 是各字段在 CSV 表头中的列名，不区分大小写，为空时使用默认列名
+type WeatherColumns struct {
+	Time          string `json:"time"`
+	Temperature   string `json:"temperature"`
+	Humidity      string `json:"humidity"`
+	WindSpeed     string `json:"wind_speed"`
+	WindDirection string `json:"wind_direction"`
+	Rain          string `json:"rain"`
+	Pressure      string `json:"pressure"`
+}
+
+func (s WeatherSettings) columns() WeatherColumns {
+	c := s.Columns
+	for _, f := range []struct {
+		field *string
+		name  string
+	}{
+		{&c.Time, "time"}, {&c.Temperature, "temperature"}, {&c.Humidity, "humidity"}, {&c.WindSpeed, "wind_speed"},
+		{&c.WindDirection, "wind_direction"}, {&c.Rain, "rain"}, {&c.Pressure, "pressure"},
+	} {
+		if *f.field == "" {
+			*f.field = f.name
+		}
+	}
+	return c
+}
+
+func (s WeatherSettings) tolerance() time.Duration {
+	if s.ToleranceMinutes > 0 {
+		return time.Duration(s.ToleranceMinutes * float64(time.Minute))
+	}
+	return 30 * time.Minute
+}
+
+func (s WeatherSettings) maxDistanceKm() float64 {
+	if s.MaxDistanceKm > 0 {
+		return s.MaxDistanceKm
+	}
+	return 25
+}
+
+// WeatherConditions 是录音开始时最近的一行气象站记录，缺少的数值为空
+type WeatherConditions struct {
+	Time          time.Time `json:"time"`              // 记录的时间
+	Station       string    `json:"station,omitempty"` // 导入的日志文件名
+	Temperature   *float64  `json:"temperature,omitempty"`
+	Humidity      *float64  `json:"humidity,omitempty"`
+	WindSpeed     *float64  `json:"wind_speed,omitempty"`
+	WindDirection *float64  `json:"wind_direction,omitempty"`
+	Rain          *float64  `json:"rain,omitempty"`
+	Pressure      *float64  `json:"pressure,omitempty"`
+}
+
+// WeatherValue 是详情页天气表格中的一行
+type WeatherValue struct {
+	Label string
+	Value string
+}
+
+// Values 返回有数值的字段及其带单位的显示值
+func (c *WeatherConditions) Values() []WeatherValue {
+	var values []WeatherValue
+	add := func(label string, v *float64, format string) {
+		if v != nil {
+			values = append(values, WeatherValue{label, fmt.Sprintf(format, *v)})
+		}
+	}
+	add("气温", c.Temperature, "%.1f °C")
+	add("相对湿度", c.Humidity, "%.0f%%")
+	if c.WindSpeed != nil && c.WindDirection != nil {
+		values = append(values, WeatherValue{"风", fmt.Sprintf("%s %.1f m/s", windDirectionName(*c.WindDirection), *c.WindSpeed)})
+	} else {
+		add("风速", c.WindSpeed, "%.1f m/s")
+		add("风向", c.WindDirection, "%.0f°")
+	}
+	add("降水", c.Rain, "%.1f mm")
+	add("气压", c.Pressure, "%.1f hPa")
+	return values
+}
+
+// Summary 把各数值连成一行，用于编辑页和命令行预览
+func (c *WeatherConditions) Summary() string {
+	var parts []string
+	for _, v := range c.Values() {
+		parts = append(parts, v.Label+" "+v.Value)
+	}
+	return strings.Join(parts, "，")
+}
+
+// windDirectionName 返回风向角对应的十六方位名称
+func windDirectionName(deg float64) string {
+	names := []string{"北", "北东北", "东北", "东东北", "东", "东东南", "东南", "南东南", "南", "南西南", "西南", "西西南", "西", "西西北", "西北", "北西北"}
+	return names[int(math.Floor(math.Mod(math.Mod(deg, 360)+360, 360)/22.5+0.5))%16] + "风"
+}
+
+// weatherTimeLayouts 是没有设置 time_layout 时依次尝试的时间格式
+var weatherTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/1/2 15:04",
+}
+
+func parseWeatherTime(value, layout string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	layouts := weatherTimeLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	// Some stations log Unix timestamps
+	if layout == "" {
+		if secs, err := strconv.ParseInt(value, 10, 64); err == nil && secs > 1e8 {
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
+
+// parseWeatherLog 读取气象站 CSV 日志，分隔符按表头自动识别为逗号、分号或制表符。
+// 结果按时间排序，时间无法识别的行会被跳过
+func parseWeatherLog(r io.Reader, settings WeatherSettings) ([]WeatherConditions, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read weather log: %w", err)
+	}
+	text := strings.TrimPrefix(string(content), "\ufeff")
+	header, _, _ := strings.Cut(text, "\n")
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = ','
+	for _, sep := range []rune{';', '\t'} {
+		if strings.Count(header, string(sep)) > strings.Count(header, string(reader.Comma)) {
+			reader.Comma = sep
+		}
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse weather log: %w", err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("weather log has no data rows")
+	}
+
+	columns := settings.columns()
+	index := func(name string) int {
+		for i, h := range rows[0] {
+			if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+				return i
+			}
+		}
+		return -1
+	}
+	timeCol := index(columns.Time)
+	if timeCol < 0 {
+		return nil, fmt.Errorf("time column %q not found in header: %s", columns.Time, strings.Join(rows[0], ", "))
+	}
+	valueCols := []struct {
+		col   int
+		field func(*WeatherConditions) **float64
+	}{
+		{index(columns.Temperature), func(c *WeatherConditions) **float64 { return &c.Temperature }},
+		{index(columns.Humidity), func(c *WeatherConditions) **float64 { return &c.Humidity }},
+		{index(columns.WindSpeed), func(c *WeatherConditions) **float64 { return &c.WindSpeed }},
+		{index(columns.WindDirection), func(c *WeatherConditions) **float64 { return &c.WindDirection }},
+		{index(columns.Rain), func(c *WeatherConditions) **float64 { return &c.Rain }},
+		{index(columns.Pressure), func(c *WeatherConditions) **float64 { return &c.Pressure }},
+	}
+	found := false
+	for _, v := range valueCols {
+		found = found || v.col >= 0
+	}
+	if !found {
+		return nil, fmt.Errorf("none of the weather columns were found in header: %s", strings.Join(rows[0], ", "))
+	}
+
+	loc := getUserTimeLocation()
+	var records []WeatherConditions
+	for _, row := range rows[1:] {
+		if timeCol >= len(row) {
+			continue
+		}
+		t, err := parseWeatherTime(row[timeCol], settings.TimeLayout, loc)
+		if err != nil {
+			continue
+		}
+		c := WeatherConditions{Time: t}
+		for _, v := range valueCols {
+			if v.col < 0 || v.col >= len(row) {
+				continue
+			}
+			// Accept a decimal comma as written by European locales
+			if f, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(row[v.col]), ",", ".", 1), 64); err == nil && !math.IsNaN(f) {
+				*v.field(&c) = &f
+			}
+		}
+		records = append(records, c)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no rows with a recognisable time in column %q", columns.Time)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// nearestWeather 返回时间最接近 t 且在 tolerance 之内的记录
+func nearestWeather(records []WeatherConditions, t time.Time, tolerance time.Duration) (*WeatherConditions, bool) {
+	i := sort.Search(len(records), func(i int) bool { return !records[i].Time.Before(t) })
+	var best *WeatherConditions
+	bestGap := tolerance
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(records) {
+			continue
+		}
+		gap := records[j].Time.Sub(t)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= bestGap {
+			best, bestGap = &records[j], gap
+		}
+	}
+	return best, best != nil
+}
+
+// WeatherMatch 是天气导入预览中的一行
+type WeatherMatch struct {
+	SourceFilename string
+	Title          string
+	RecordDate     time.Time
+	Current        *WeatherConditions // 已有的天气记录
+	Conditions     *WeatherConditions // 匹配到的记录，未匹配时为空
+	Skipped        string             // 未匹配的原因
+}
+
+// GapMinutes 返回匹配到的记录与录音开始时间相差的分钟数
+func (m WeatherMatch) GapMinutes() float64 {
+	if m.Conditions == nil {
+		return 0
+	}
+	return math.Abs(m.Conditions.Time.Sub(m.RecordDate).Minutes())
+}
+
+// matchWeather 为每条录音查找最近的气象站记录。设置了气象站坐标时，有坐标且距离过远的录音不匹配
+func matchWeather(recordings []AudioMetadata, records []WeatherConditions, station string, settings WeatherSettings) []WeatherMatch {
+	matches := make([]WeatherMatch, 0, len(recordings))
+	for _, meta := range recordings {
+		m := WeatherMatch{SourceFilename: meta.SourceFilename, Title: meta.Title, RecordDate: meta.RecordDate, Current: meta.Weather}
+		tooFar := settings.StationLatitude != nil && settings.StationLongitude != nil && meta.Latitude != nil && meta.Longitude != nil &&
+			haversineMeters(*settings.StationLatitude, *settings.StationLongitude, *meta.Latitude, *meta.Longitude)/1000 > settings.maxDistanceKm()
+		if tooFar {
+			m.Skipped = fmt.Sprintf("距离气象站超过 %g km", settings.maxDistanceKm())
+		} else if c, ok := nearestWeather(records, meta.RecordDate, settings.tolerance()); ok {
+			conditions := *c
+			conditions.Station = station
+			m.Conditions = &conditions
+		} else {
+			m.Skipped = fmt.Sprintf("前后 %g 分钟内没有记录", settings.tolerance().Minutes())
+		}
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].RecordDate.Before(matches[j].RecordDate) })
+	return matches
+}
+
+// applyWeather 把天气记录写入录音的元数据
+func applyWeather(sourceFilename string, conditions WeatherConditions) error {
+	jsonPath := filepath.Join(jsonDir, sidecarBase(sourceFilename)+".json")
+	metadata, err := loadAudioMetadata(jsonPath)
+	if err != nil {
+		return err
+	}
+	metadata.Weather = &conditions
+	updatedJsonContent, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal json for %s: %w", sourceFilename, err)
+	}
+	if err := os.WriteFile(jsonPath, updatedJsonContent, 0644); err != nil {
+		return fmt.Errorf("failed to write json file %s: %w", jsonPath, err)
+	}
+	return nil
+}
+
+// formatWeatherReport 把匹配结果格式化为命令行预览
+func formatWeatherReport(matches []WeatherMatch) string {
+	var b strings.Builder
+	matched := 0
+	for _, m := range matches {
+		name := filepath.ToSlash(m.SourceFilename)
+		if m.Conditions == nil {
+			fmt.Fprintf(&b, "skipped  %s  %s (%s)\n", m.RecordDate.Format("2006-01-02 15:04:05"), name, m.Skipped)
+			continue
+		}
+		matched++
+		fmt.Fprintf(&b, "matched  %s  %s  %s (row %s, %.0f min away)\n", m.RecordDate.Format("2006-01-02 15:04:05"), name,
+			m.Conditions.Summary(), m.Conditions.Time.Format("15:04"), m.GapMinutes())
+	}
+	fmt.Fprintf(&b, "%d of %d recordings matched\n", matched, len(matches))
+	return b.String()
+}
+
+// runWeatherCommand 是 -weather 命令行模式：打印预览，apply 为 true 时保存匹配到的天气
+func runWeatherCommand(logPath, folder string, apply bool) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := parseWeatherLog(f, settings.Weather)
+	if err != nil {
+		return err
+	}
+	recordings, err := folderRecordings(folder)
+	if err != nil {
+		return err
+	}
+	matches := matchWeather(recordings, records, filepath.Base(logPath), settings.Weather)
+	fmt.Print(formatWeatherReport(matches))
+	if !apply {
+		fmt.Println("Preview only, run again with -apply to save these conditions.")
+		return nil
+	}
+	for _, m := range matches {
+		if m.Conditions != nil {
+			if err := applyWeather(m.SourceFilename, *m.Conditions); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("Weather saved.")
+	return nil
+}